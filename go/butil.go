@@ -13,6 +13,24 @@ import (
 // Buffers encoded with different versions cannot be decoded.
 const ProtocolVersion uint32 = 1
 
+// Header flags are stored in the upper 16 bits of the protocol version header, so that
+// decoders which do not know about a flag reject the buffer with ErrVersion.
+const (
+	versionMask uint32 = 0xffff
+
+	// flagDelta marks a delta created by EncodeDelta, which Decode must not read as a message.
+	flagDelta uint32 = 1 << 16
+	// flagFlate and flagGzip mark a payload compressed with the respective format.
+	flagFlate uint32 = 1 << 17
+	flagGzip  uint32 = 1 << 18
+	// flagChecksum marks a message that ends with a CRC-32C checksum.
+	flagChecksum uint32 = 1 << 19
+	// flagSealed marks a message with an encrypted and authenticated payload.
+	flagSealed uint32 = 1 << 20
+	// flagSigned marks a message with a signature block.
+	flagSigned uint32 = 1 << 21
+)
+
 var (
 	// ErrVersion indicates an incompatible version of a buffer.
 	// This occurs when trying to decode data encoded with a different protocol version.
@@ -29,6 +47,33 @@ var (
 	// ErrModel indicates an error with the model schema.
 	// This includes references to non-existent fields or schema inconsistencies.
 	ErrModel = errors.New("invalid model")
+
+	// ErrNotFound indicates that a buffer does not contain the value at a path.
+	// This occurs when a field is not set, or a list index or map key does not exist.
+	ErrNotFound = errors.New("value not found")
+
+	// ErrChecksum indicates that the checksum of a buffer does not match its content.
+	// This occurs when a buffer was corrupted after it was encoded with a checksum.
+	ErrChecksum = errors.New("checksum mismatch")
+
+	// ErrAuthentication indicates that a sealed buffer could not be authenticated.
+	// This occurs when a buffer was tampered with, or the key it was sealed with is not available.
+	ErrAuthentication = errors.New("message authentication failed")
+
+	// ErrSignature indicates a missing or invalid signature.
+	// This occurs when a buffer is verified that is not signed by a known key, or was changed after signing.
+	ErrSignature = errors.New("invalid signature")
+
+	// ErrEncrypted indicates that the value of an encrypted field could not be decrypted.
+	// This occurs when the model has no key for the value, and the destination cannot hold an EncryptedValue.
+	ErrEncrypted = errors.New("encrypted value not decrypted")
+
+	// ErrClosed indicates that an RPC connection or server was closed.
+	// This occurs when calls are made on a closed client, or the connection fails while calls are in flight.
+	ErrClosed = errors.New("connection closed")
+
+	// ErrServerClosed is returned by Server.Serve after the server was shut down or closed.
+	ErrServerClosed = errors.New("server closed")
 )
 
 var bufferPool = sync.Pool{
@@ -43,6 +88,7 @@ type ModelField struct {
 	label      string
 	fieldType  BuftiType
 	isRequired *bool
+	encrypted  bool
 }
 
 // Field creates a new model field with the given index, label, and type.
@@ -84,7 +130,9 @@ type Model struct {
 	name       string
 	schema     map[byte]ModelField
 	labels     map[string]byte
-	fieldCache map[reflect.Type]map[string]reflect.Value
+	fieldCache map[reflect.Type]map[string]int
+	fieldKeys  KeyProvider
+	fieldKeyID uint32
 	mu         sync.RWMutex
 }
 
@@ -103,8 +151,7 @@ func NewModel(fields ...ModelField) (*Model, error) {
 			trueValue := true
 			f.isRequired = &trueValue
 		}
-		m.labels[f.label] = f.index
-		m.schema[f.index] = f
+		m.addField(f)
 	}
 
 	if err := m.Validate(); err != nil {
@@ -141,8 +188,7 @@ func NewModelWithOptions(options *ModelOptions, fields ...ModelField) (*Model, e
 				f.isRequired = &falseValue
 			}
 		}
-		m.labels[f.label] = f.index
-		m.schema[f.index] = f
+		m.addField(f)
 	}
 
 	if err := m.Validate(); err != nil {
@@ -171,6 +217,25 @@ func (m *Model) Validate() error {
 	return nil
 }
 
+// addField adds a field to the schema, wrapping the type of encrypted fields.
+func (m *Model) addField(f ModelField) {
+	if f.encrypted {
+		f.fieldType = encryptedType{fieldType: f.fieldType, model: m, index: f.index}
+	}
+	m.labels[f.label] = f.index
+	m.schema[f.index] = f
+}
+
+// indices returns the field indices of the model in ascending order.
+func (m *Model) indices() []byte {
+	indices := make([]byte, 0, len(m.schema))
+	for index := range m.schema {
+		indices = append(indices, index)
+	}
+	slices.Sort(indices)
+	return indices
+}
+
 // Used for unit testing
 func newModel(fields ...ModelField) *Model {
 	model, _ := NewModel(fields...)
@@ -51,6 +51,13 @@ var complexModel = newModelWithOptions(
 	Field(6, "data", Bytes),
 )
 
+var nestedModel = newModelWithOptions(
+	&ModelOptions{Name: "nested model", RequiredByDefault: false},
+	Field(0, "id", Int64),
+	Field(1, "simple", Reference(simpleModel)),
+	Field(2, "children", List(Reference(simpleModel))),
+)
+
 // Test Basic Types
 func TestSimpleTypes(t *testing.T) {
 	tests := []struct {
@@ -137,12 +144,12 @@ func TestSimpleTypes(t *testing.T) {
 			input:    map[string]any{"value": "Hello, 世界! 🌍"},
 			expected: map[string]any{"value": "Hello, 世界! 🌍"},
 		},
-		//{
-		//	name:     "empty_string",
-		//	model:    newModel(Field(0, "value", String)),
-		//	input:    map[string]any{"value": ""},
-		//	expected: map[string]any{"value": ""},
-		//},
+		{
+			name:     "empty_string",
+			model:    newModel(Field(0, "value", String)),
+			input:    map[string]any{"value": ""},
+			expected: map[string]any{"value": ""},
+		},
 		{
 			name:     "bytes",
 			model:    newModel(Field(0, "value", Bytes)),
@@ -206,6 +213,23 @@ func TestStructEncodeDecodeBasic(t *testing.T) {
 	}
 }
 
+func TestStructEncodeMultipleValues(t *testing.T) {
+	for _, original := range []SimpleStruct{{ID: 1, Name: "first"}, {ID: 2, Name: "second"}} {
+		encoded, err := simpleModel.Encode(original)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+
+		var decoded SimpleStruct
+		if err := simpleModel.Decode(encoded, &decoded); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if decoded != original {
+			t.Errorf("Expected %+v, got %+v", original, decoded)
+		}
+	}
+}
+
 func TestMapEncodeDecodeBasic(t *testing.T) {
 	original := map[string]any{
 		"id":   int64(12345678901234),
@@ -0,0 +1,29 @@
+package butil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// appendChecksum sets the checksum flag of a message and appends the CRC-32C checksum of the result.
+// The checksum covers everything before it, including the header.
+func appendChecksum(message []byte) []byte {
+	binary.LittleEndian.PutUint32(message, binary.LittleEndian.Uint32(message)|flagChecksum)
+	return binary.LittleEndian.AppendUint32(message, crc32.Checksum(message, castagnoli))
+}
+
+// verifyChecksum checks the checksum at the end of a message, and returns the payload between the header and the checksum.
+func verifyChecksum(data []byte) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("%w: failed to read checksum", ErrBuffer)
+	}
+	end := len(data) - 4
+	expected := binary.LittleEndian.Uint32(data[end:])
+	if actual := crc32.Checksum(data[:end], castagnoli); actual != expected {
+		return nil, fmt.Errorf("%w: expected %08x, got %08x", ErrChecksum, expected, actual)
+	}
+	return data[4:end], nil
+}
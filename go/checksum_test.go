@@ -0,0 +1,56 @@
+package butil
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	original := compressibleStruct()
+
+	for _, compression := range []Compression{None, Gzip} {
+		t.Run(compression.String(), func(t *testing.T) {
+			encoded, err := complexModel.EncodeWithOptions(original, &EncodeOptions{Compression: compression, Checksum: true})
+			if err != nil {
+				t.Fatalf("EncodeWithOptions failed: %v", err)
+			}
+
+			var decoded ComplexStruct
+			if err := complexModel.Decode(encoded, &decoded); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Error("Decoded value differs from the original")
+			}
+
+			// Every flipped bit is detected, including those in the header and the checksum itself.
+			for _, offset := range []int{0, 3, 4, len(encoded) / 2, len(encoded) - 5, len(encoded) - 1} {
+				corrupted := bytes.Clone(encoded)
+				corrupted[offset] ^= 0x10
+				err := complexModel.Decode(corrupted, &decoded)
+				if !errors.Is(err, ErrChecksum) && !errors.Is(err, ErrVersion) {
+					t.Errorf("Offset %d: expected ErrChecksum, got %v", offset, err)
+				}
+			}
+		})
+	}
+}
+
+func TestChecksumVersion(t *testing.T) {
+	encoded, err := simpleModel.EncodeWithOptions(SimpleStruct{ID: 1}, &EncodeOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The flag is part of the version header, so decoders without checksum support reject the buffer.
+	buf := bytes.NewBuffer(encoded)
+	if err := readVersion(buf); !errors.Is(err, ErrVersion) {
+		t.Errorf("Expected ErrVersion, got %v", err)
+	}
+
+	if err := simpleModel.Decode(encoded[:7], &SimpleStruct{}); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a buffer shorter than the checksum, got %v", err)
+	}
+}
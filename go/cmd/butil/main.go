@@ -0,0 +1,207 @@
+// Command butil inspects and converts buffers encoded with the butil binary protocol.
+//
+// Usage:
+//
+//	butil <command> -schema file [-model name] [flags] [input]
+//
+// The commands are:
+//
+//	decode       decode a buffer to JSON or text
+//	encode       encode JSON or text to a buffer
+//	dump         print an annotated hex dump of a buffer
+//	validate     check that a buffer decodes according to the model
+//	fingerprint  print the fingerprint of the model
+//
+// The schema is read from a definition file as accepted by butil.ParseSchema.
+// Input is read from the named file, or from standard input if no file or "-" is given,
+// and output is written to standard output unless -o is set.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	butil "github.com/QYUbit/Butil/go"
+)
+
+const usage = `usage: butil <command> -schema file [-model name] [flags] [input]
+
+commands:
+  decode       decode a buffer to JSON or text
+  encode       encode JSON or text to a buffer
+  dump         print an annotated hex dump of a buffer
+  validate     check that a buffer decodes according to the model
+  fingerprint  print the fingerprint of the model
+
+Run 'butil <command> -h' for the flags of a command.
+`
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "butil: %v\n", err)
+		}
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(os.Stderr, usage)
+		}
+		os.Exit(1)
+	}
+}
+
+// command holds the flags shared by all commands.
+type command struct {
+	flags      *flag.FlagSet
+	schemaPath string
+	modelName  string
+	format     string
+	outputPath string
+}
+
+func newCommand(name string) *command {
+	c := &command{flags: flag.NewFlagSet(name, flag.ContinueOnError)}
+	c.flags.StringVar(&c.schemaPath, "schema", "", "schema definition `file`")
+	c.flags.StringVar(&c.modelName, "model", "", "`name` of the model to use (defaults to the only model in the schema)")
+	c.flags.StringVar(&c.outputPath, "o", "", "write output to `file` instead of standard output")
+	if name == "decode" || name == "encode" {
+		c.flags.StringVar(&c.format, "format", "json", "textual representation: json or text")
+	}
+	return c
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+
+	name := args[0]
+	if !slices.Contains([]string{"decode", "encode", "dump", "validate", "fingerprint"}, name) {
+		return fmt.Errorf("%w: unknown command %q", errUsage, name)
+	}
+
+	c := newCommand(name)
+	if err := c.flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if c.format != "" && c.format != "json" && c.format != "text" {
+		return fmt.Errorf("unknown format %q", c.format)
+	}
+
+	model, err := c.loadModel()
+	if err != nil {
+		return err
+	}
+
+	if name == "fingerprint" {
+		return c.writeOutput(stdout, []byte(fmt.Sprintf("%016x\n", model.Fingerprint())))
+	}
+
+	input, err := c.readInput(stdin)
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "decode":
+		var output []byte
+		if c.format == "text" {
+			text, err := butil.Format(model, input)
+			if err != nil {
+				return err
+			}
+			output = []byte(text)
+		} else {
+			output, err = butil.ToJSON(model, input)
+			if err != nil {
+				return err
+			}
+			output = append(output, '\n')
+		}
+		return c.writeOutput(stdout, output)
+
+	case "encode":
+		var output []byte
+		if c.format == "text" {
+			output, err = butil.Parse(model, string(input))
+		} else {
+			output, err = butil.FromJSON(model, input)
+		}
+		if err != nil {
+			return err
+		}
+		return c.writeOutput(stdout, output)
+
+	case "dump":
+		var sb strings.Builder
+		dumpErr := butil.Dump(model, input, &sb)
+		if err := c.writeOutput(stdout, []byte(sb.String())); err != nil {
+			return err
+		}
+		return dumpErr
+
+	default:
+		fields := make(map[string]any)
+		if err := model.Decode(input, &fields); err != nil {
+			return err
+		}
+		return c.writeOutput(stdout, []byte("ok\n"))
+	}
+}
+
+func (c *command) loadModel() (*butil.Model, error) {
+	if c.schemaPath == "" {
+		return nil, fmt.Errorf("%w: missing -schema", errUsage)
+	}
+
+	src, err := os.ReadFile(c.schemaPath)
+	if err != nil {
+		return nil, err
+	}
+	models, err := butil.ParseSchema(string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.modelName != "" {
+		model, exists := models[c.modelName]
+		if !exists {
+			return nil, fmt.Errorf("model %s not found in %s", c.modelName, c.schemaPath)
+		}
+		return model, nil
+	}
+	if len(models) != 1 {
+		return nil, fmt.Errorf("%w: %s defines %d models, choose one with -model", errUsage, c.schemaPath, len(models))
+	}
+	for _, model := range models {
+		return model, nil
+	}
+	return nil, nil
+}
+
+func (c *command) readInput(stdin io.Reader) ([]byte, error) {
+	switch c.flags.NArg() {
+	case 0:
+		return io.ReadAll(stdin)
+	case 1:
+		if c.flags.Arg(0) == "-" {
+			return io.ReadAll(stdin)
+		}
+		return os.ReadFile(c.flags.Arg(0))
+	default:
+		return nil, fmt.Errorf("%w: expected at most one input file", errUsage)
+	}
+}
+
+func (c *command) writeOutput(stdout io.Writer, output []byte) error {
+	if c.outputPath != "" {
+		return os.WriteFile(c.outputPath, output, 0o644)
+	}
+	_, err := stdout.Write(output)
+	return err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	butil "github.com/QYUbit/Butil/go"
+)
+
+const (
+	scalarsSchema = "../../testdata/conformance/scalars.butil"
+	nestedSchema  = "../../testdata/conformance/nested.butil"
+)
+
+func runCommand(t *testing.T, stdin []byte, args ...string) ([]byte, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	err := run(args, bytes.NewReader(stdin), &stdout)
+	return stdout.Bytes(), err
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		input string
+	}{
+		{name: "scalars", args: []string{"-schema", scalarsSchema}, input: `{"b": true, "i32": -7, "f64": 0.5, "text": "x"}`},
+		{name: "non_finite", args: []string{"-schema", scalarsSchema}, input: `{"f32": "-Inf", "f64": "NaN"}`},
+		{name: "nested", args: []string{"-schema", nestedSchema, "-model", "node"}, input: `{"id": 1, "child": {"id": 2}, "named": {"a": {"name": "leaf"}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := runCommand(t, []byte(tt.input), append([]string{"encode"}, tt.args...)...)
+			if err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+
+			for _, command := range []string{"validate", "dump"} {
+				if _, err := runCommand(t, encoded, append([]string{command}, tt.args...)...); err != nil {
+					t.Errorf("%s failed: %v", command, err)
+				}
+			}
+
+			decoded, err := runCommand(t, encoded, append([]string{"decode"}, tt.args...)...)
+			if err != nil {
+				t.Fatalf("decode failed: %v", err)
+			}
+			var expected, actual any
+			if err := json.Unmarshal([]byte(tt.input), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(decoded, &actual); err != nil {
+				t.Fatalf("decode wrote invalid JSON %s: %v", decoded, err)
+			}
+			if !reflect.DeepEqual(expected, actual) {
+				t.Errorf("Expected %s, got %s", tt.input, decoded)
+			}
+
+			// The text format reads back to the same buffer.
+			text, err := runCommand(t, encoded, append([]string{"decode", "-format", "text"}, tt.args...)...)
+			if err != nil {
+				t.Fatalf("decode -format text failed: %v", err)
+			}
+			reencoded, err := runCommand(t, text, append([]string{"encode", "-format", "text"}, tt.args...)...)
+			if err != nil {
+				t.Fatalf("encode -format text of %s failed: %v", text, err)
+			}
+			if !bytes.Equal(reencoded, encoded) {
+				t.Errorf("Text round trip through %s changed the buffer", text)
+			}
+		})
+	}
+}
+
+func TestDump(t *testing.T) {
+	encoded, err := runCommand(t, []byte(`{"u16": 513}`), "encode", "-schema", scalarsSchema)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	output, err := runCommand(t, encoded, "dump", "-schema", scalarsSchema)
+	if err != nil {
+		t.Fatalf("dump failed: %v", err)
+	}
+	if !strings.Contains(string(output), "u16") || !strings.Contains(string(output), "01 02") {
+		t.Errorf("Expected the dump to annotate u16 = 01 02, got:\n%s", output)
+	}
+
+	// A truncated buffer is dumped up to the error.
+	output, err = runCommand(t, encoded[:len(encoded)-1], "dump", "-schema", scalarsSchema)
+	if !errors.Is(err, butil.ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a truncated buffer, got %v", err)
+	}
+	if len(output) == 0 {
+		t.Error("Expected the dump of a truncated buffer to show what was read")
+	}
+}
+
+func TestFilesAndFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	output := filepath.Join(dir, "output.butil")
+	if err := os.WriteFile(input, []byte(`{"id": 3}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runCommand(t, nil, "encode", "-schema", nestedSchema, "-model", "node", "-o", output, input); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	decoded, err := runCommand(t, nil, "decode", "-schema", nestedSchema, "-model", "node", output)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if string(decoded) != "{\"id\":3}\n" {
+		t.Errorf(`Expected {"id":3}, got %s`, decoded)
+	}
+
+	fingerprint, err := runCommand(t, nil, "fingerprint", "-schema", scalarsSchema)
+	if err != nil {
+		t.Fatalf("fingerprint failed: %v", err)
+	}
+	if len(fingerprint) != 17 {
+		t.Errorf("Expected 16 hex digits and a newline, got %q", fingerprint)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		input    string
+		expected error
+	}{
+		{name: "no_command", args: nil, expected: errUsage},
+		{name: "unknown_command", args: []string{"convert"}, expected: errUsage},
+		{name: "missing_schema", args: []string{"decode"}, expected: errUsage},
+		{name: "ambiguous_model", args: []string{"fingerprint", "-schema", nestedSchema}, expected: errUsage},
+		{name: "too_many_inputs", args: []string{"decode", "-schema", scalarsSchema, "a", "b"}, expected: errUsage},
+		{name: "help", args: []string{"decode", "-h"}, expected: flag.ErrHelp},
+		{name: "invalid_json", args: []string{"encode", "-schema", scalarsSchema}, input: `{"u8": 300}`, expected: butil.ErrInput},
+		{name: "invalid_buffer", args: []string{"validate", "-schema", scalarsSchema}, input: "\x02\x00\x00\x00", expected: butil.ErrVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := runCommand(t, []byte(tt.input), tt.args...); !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
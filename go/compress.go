@@ -0,0 +1,122 @@
+package butil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Compression is a format that encoded payloads can be compressed with.
+type Compression int
+
+const (
+	// None leaves the payload uncompressed.
+	None Compression = iota
+	// Flate compresses the payload with DEFLATE as in compress/flate.
+	Flate
+	// Gzip compresses the payload with gzip as in compress/gzip.
+	Gzip
+)
+
+// DefaultMaxDecompressedSize is the largest size a compressed payload may expand to
+// if DecodeOptions.MaxDecompressedSize is not set.
+const DefaultMaxDecompressedSize = 64 << 20
+
+func (c Compression) String() string {
+	switch c {
+	case None:
+		return "none"
+	case Flate:
+		return "flate"
+	case Gzip:
+		return "gzip"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// compress compresses the payload of an encoded message, and sets the matching header flag.
+// The compressed message is the header, the size of the uncompressed payload as uint32, and the
+// compressed payload. Payloads smaller than threshold, or which do not get smaller, are left as they are.
+func compress(encoded []byte, compression Compression, threshold int) ([]byte, error) {
+	payload := encoded[4:]
+	if compression == None || len(payload) < threshold {
+		return encoded, nil
+	}
+
+	var flag uint32
+	buf := new(bytes.Buffer)
+	buf.Write(encoded[:4])
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(payload))); err != nil {
+		return nil, err
+	}
+
+	var w io.WriteCloser
+	switch compression {
+	case Flate:
+		flag = flagFlate
+		w, _ = flate.NewWriter(buf, flate.DefaultCompression)
+	case Gzip:
+		flag = flagGzip
+		w = gzip.NewWriter(buf)
+	default:
+		return nil, fmt.Errorf("%w: unknown compression %s", ErrInput, compression)
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(encoded) {
+		return encoded, nil
+	}
+	compressed := buf.Bytes()
+	binary.LittleEndian.PutUint32(compressed, binary.LittleEndian.Uint32(encoded)|flag)
+	return compressed, nil
+}
+
+// decompress expands the payload of a message with the given header, without expanding to more than limit bytes.
+// The payload starts with the uncompressed size as uint32.
+func decompress(header uint32, payload []byte, limit int) ([]byte, error) {
+	if limit <= 0 {
+		limit = DefaultMaxDecompressedSize
+	}
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("%w: failed to read uncompressed size", ErrBuffer)
+	}
+	size := binary.LittleEndian.Uint32(payload)
+	if uint64(size) > uint64(limit) {
+		return nil, fmt.Errorf("%w: uncompressed size %d exceeds limit of %d bytes", ErrBuffer, size, limit)
+	}
+
+	var r io.Reader
+	compressed := bytes.NewReader(payload[4:])
+	if header&flagGzip != 0 {
+		zr, err := gzip.NewReader(compressed)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid gzip payload: %w", ErrBuffer, err)
+		}
+		r = zr
+	} else {
+		r = flate.NewReader(compressed)
+	}
+
+	// The size is only trusted as a limit, the buffer grows with the data that is actually read.
+	plain := new(bytes.Buffer)
+	if _, err := io.CopyN(plain, r, int64(size)); err != nil {
+		return nil, fmt.Errorf("%w: compressed payload is shorter than its size %d: %w", ErrBuffer, size, err)
+	}
+	var extra [1]byte
+	if _, err := io.ReadFull(r, extra[:]); err != io.EOF {
+		if err == nil {
+			return nil, fmt.Errorf("%w: compressed payload is longer than its size %d", ErrBuffer, size)
+		}
+		return nil, fmt.Errorf("%w: invalid compressed payload: %w", ErrBuffer, err)
+	}
+	return plain.Bytes(), nil
+}
@@ -0,0 +1,124 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func compressibleStruct() ComplexStruct {
+	tags := make([]string, 100)
+	for i := range tags {
+		tags[i] = strings.Repeat("tag", 10)
+	}
+	return ComplexStruct{ID: 1, Name: "compressed", Tags: tags, Scores: []float64{}, Metadata: map[string]int64{}, Data: make([]byte, 1000)}
+}
+
+func TestCompression(t *testing.T) {
+	original := compressibleStruct()
+	plain, err := complexModel.Encode(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []Compression{None, Flate, Gzip} {
+		t.Run(compression.String(), func(t *testing.T) {
+			encoded, err := complexModel.EncodeWithOptions(original, &EncodeOptions{Compression: compression})
+			if err != nil {
+				t.Fatalf("EncodeWithOptions failed: %v", err)
+			}
+			if compression == None {
+				if !bytes.Equal(encoded, plain) {
+					t.Errorf("Expected uncompressed payload to equal Encode output")
+				}
+			} else if len(encoded) >= len(plain)/4 {
+				t.Errorf("Expected compressed size below %d, got %d", len(plain)/4, len(encoded))
+			}
+
+			var decoded ComplexStruct
+			if err := complexModel.Decode(encoded, &decoded); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Error("Decoded value differs from the original")
+			}
+
+			var name struct {
+				Name string `butil:"name"`
+			}
+			if err := complexModel.DecodeFields(encoded, &name, "name"); err != nil {
+				t.Fatalf("DecodeFields failed: %v", err)
+			}
+			if name.Name != original.Name {
+				t.Errorf("Expected name %q, got %q", original.Name, name.Name)
+			}
+		})
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	original := SimpleStruct{ID: 1, Name: strings.Repeat("a", 100)}
+	plain, err := simpleModel.Encode(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := simpleModel.EncodeWithOptions(original, &EncodeOptions{Compression: Gzip, CompressionThreshold: len(plain)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, plain) {
+		t.Error("Expected payload below the threshold not to be compressed")
+	}
+
+	encoded, err = simpleModel.EncodeWithOptions(original, &EncodeOptions{Compression: Gzip, CompressionThreshold: len(plain) - 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.LittleEndian.Uint32(encoded) != ProtocolVersion|flagGzip {
+		t.Errorf("Expected payload at the threshold to be compressed, got header %#x", binary.LittleEndian.Uint32(encoded))
+	}
+}
+
+func TestCompressionErrors(t *testing.T) {
+	original := compressibleStruct()
+	encoded, err := complexModel.EncodeWithOptions(original, &EncodeOptions{Compression: Flate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded ComplexStruct
+	if err := complexModel.DecodeWithOptions(encoded, &decoded, &DecodeOptions{MaxDecompressedSize: 100}); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a payload above the limit, got %v", err)
+	}
+
+	// A payload that expands beyond its declared size is rejected before it is read completely.
+	bomb := bytes.Clone(encoded)
+	binary.LittleEndian.PutUint32(bomb[4:], 10)
+	if err := complexModel.Decode(bomb, &decoded); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a payload longer than its size, got %v", err)
+	}
+
+	if err := complexModel.Decode(encoded[:len(encoded)/2], &decoded); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a truncated payload, got %v", err)
+	}
+
+	gzipped, err := complexModel.EncodeWithOptions(original, &EncodeOptions{Compression: Gzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipped[len(gzipped)-5] ^= 0xff
+	if err := complexModel.Decode(gzipped, &decoded); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a corrupted gzip payload, got %v", err)
+	}
+
+	if _, _, err := Get(complexModel, encoded, "name"); !errors.Is(err, ErrVersion) {
+		t.Errorf("Expected Get to reject compressed buffers with ErrVersion, got %v", err)
+	}
+	if _, err := complexModel.EncodeWithOptions(original, &EncodeOptions{Compression: 7}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unknown compression, got %v", err)
+	}
+}
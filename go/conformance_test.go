@@ -0,0 +1,100 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// conformanceFile is a set of test vectors for one model, see testdata/conformance/README.md.
+type conformanceFile struct {
+	Model string `json:"model"`
+	Cases []struct {
+		Name  string          `json:"name"`
+		Value json.RawMessage `json:"value"`
+		Bytes string          `json:"bytes"`
+	} `json:"cases"`
+}
+
+func TestConformance(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "conformance", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("No conformance vectors found")
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			schema, err := os.ReadFile(strings.TrimSuffix(path, ".json") + ".butil")
+			if err != nil {
+				t.Fatal(err)
+			}
+			models, err := ParseSchema(string(schema))
+			if err != nil {
+				t.Fatalf("ParseSchema failed: %v", err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var file conformanceFile
+			if err := json.Unmarshal(content, &file); err != nil {
+				t.Fatalf("Invalid vector file: %v", err)
+			}
+
+			model, exists := models[file.Model]
+			if !exists {
+				t.Fatalf("Model %s not found in schema", file.Model)
+			}
+
+			for _, tc := range file.Cases {
+				t.Run(tc.Name, func(t *testing.T) {
+					runConformanceCase(t, model, tc.Value, tc.Bytes)
+				})
+			}
+		})
+	}
+}
+
+func runConformanceCase(t *testing.T, model *Model, value json.RawMessage, expectedHex string) {
+	expected, err := hex.DecodeString(strings.Join(strings.Fields(expectedHex), ""))
+	if err != nil {
+		t.Fatalf("Invalid expected bytes: %v", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		t.Fatalf("Invalid value: %v", err)
+	}
+	input, err := fromJSONValue(Reference(model), raw)
+	if err != nil {
+		t.Fatalf("Value does not match the model: %v", err)
+	}
+
+	encoded, err := model.Encode(input)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encoded bytes differ\nexpected %x\ngot      %x", expected, encoded)
+	}
+
+	decoded := make(map[string]any)
+	if err := model.Decode(expected, &decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, input) {
+		t.Errorf("Expected %v, got %v", input, decoded)
+	}
+}
@@ -3,43 +3,57 @@ package butil
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
 )
 
 // Decode deserializes binary data into the given destination according to the model schema.
 // The destination must be a pointer to a struct or map[string]any.
 // Struct fields are mapped from schema fields using either the field name or the `butil` tag.
+// Checksums and compressed payloads are detected from the header. Checksums are verified
+// before anything is parsed, and payloads are decompressed with the default DecodeOptions.
 //
 // Returns ErrInput if dest is not a pointer or is nil.
 // Returns ErrVersion if the data was encoded with an incompatible protocol version.
+// Returns ErrChecksum if the data has a checksum that does not match.
 // Returns ErrBuffer if the data is corrupted or cannot be parsed.
 // Returns ErrModel if the data references fields not defined in the schema.
+// Returns FieldErrors wrapping ErrEncrypted for encrypted fields that could not be decrypted,
+// after decoding the other fields.
 func (m *Model) Decode(data []byte, dest any) error {
-	v := reflect.ValueOf(dest)
-	t := reflect.TypeOf(dest)
-
-	if t.Kind() != reflect.Pointer || v.IsNil() {
-		return fmt.Errorf("%w: dest has to be a pointer, instead: %s", ErrInput, t.Kind())
-	}
-
-	buf := bufferPool.Get().(*bytes.Buffer)
-	defer bufferPool.Put(buf)
-
-	buf.Reset()
-	if _, err := buf.Write(data); err != nil {
-		return err
-	}
+	return m.DecodeWithOptions(data, dest, nil)
+}
 
+// readVersion reads the protocol version header and checks it against ProtocolVersion.
+func readVersion(buf *bytes.Buffer) error {
 	var version uint32
 	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
 		return fmt.Errorf("%w: failed to read protocol version", ErrBuffer)
 	}
-	if version != ProtocolVersion {
+	return checkVersion(version)
+}
+
+// checkVersion checks a protocol version header that is expected to have no flags set.
+func checkVersion(header uint32) error {
+	if version := header & versionMask; version != ProtocolVersion {
 		return fmt.Errorf("%w: incompatible butil version: this package uses version %d, buffer uses version %d", ErrVersion, ProtocolVersion, version)
 	}
+	if flags := header &^ versionMask; flags != 0 {
+		return fmt.Errorf("%w: unsupported header flags %#x", ErrVersion, flags)
+	}
+	return nil
+}
 
-	return m.decode(buf, t, v)
+// readUint32 reads a little-endian length or count without going through binary.Read,
+// which allocates on every call.
+func readUint32(buf *bytes.Buffer, what string) (uint32, error) {
+	b := buf.Next(4)
+	if len(b) < 4 {
+		return 0, fmt.Errorf("%w: failed to read %s: %w", ErrBuffer, what, io.ErrUnexpectedEOF)
+	}
+	return binary.LittleEndian.Uint32(b), nil
 }
 
 func (m *Model) decode(buf *bytes.Buffer, t reflect.Type, v reflect.Value) error {
@@ -63,6 +77,7 @@ func (m *Model) decode(buf *bytes.Buffer, t reflect.Type, v reflect.Value) error
 
 func (m *Model) decodeStruct(buf *bytes.Buffer, t reflect.Type, v reflect.Value, fieldCount int) error {
 	fieldMap := make(map[string]reflect.Value, len(m.schema))
+	var encrypted []error
 
 	for i := range t.NumField() {
 		field := t.Field(i)
@@ -103,10 +118,24 @@ func (m *Model) decodeStruct(buf *bytes.Buffer, t reflect.Type, v reflect.Value,
 			return fmt.Errorf("%w: index %d does not exist on model %s", ErrBuffer, index, m.name)
 		}
 
-		if err = schemaField.fieldType.Decode(buf, fieldMap[schemaField.label]); err != nil {
-			return err
+		value, exists := fieldMap[schemaField.label]
+		if !exists {
+			if err := skipValue(buf, schemaField.fieldType); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err = schemaField.fieldType.Decode(buf, value); err != nil {
+			if !undecrypted(schemaField.fieldType, err) {
+				return unread(err)
+			}
+			encrypted = append(encrypted, err)
 		}
 	}
+	if len(encrypted) > 0 {
+		return fieldErrors(encrypted)
+	}
 	return nil
 }
 
@@ -116,6 +145,7 @@ func (m *Model) decodeMap(buf *bytes.Buffer, t reflect.Type, v reflect.Value, fi
 	}
 
 	decodedFields := make(map[byte]bool)
+	var encrypted []error
 	for range fieldCount {
 		index, err := buf.ReadByte()
 		if err != nil {
@@ -131,7 +161,10 @@ func (m *Model) decodeMap(buf *bytes.Buffer, t reflect.Type, v reflect.Value, fi
 
 		var mapValue any
 		if err = schemaField.fieldType.Decode(buf, reflect.ValueOf(&mapValue).Elem()); err != nil {
-			return err
+			if !undecrypted(schemaField.fieldType, err) {
+				return unread(err)
+			}
+			encrypted = append(encrypted, err)
 		}
 		v.SetMapIndex(reflect.ValueOf(schemaField.label), reflect.ValueOf(mapValue))
 	}
@@ -147,5 +180,116 @@ func (m *Model) decodeMap(buf *bytes.Buffer, t reflect.Type, v reflect.Value, fi
 			return fmt.Errorf("%w: required field %s is missing for model %s", ErrBuffer, field.label, m.name)
 		}
 	}
+	if len(encrypted) > 0 {
+		return fieldErrors(encrypted)
+	}
+	return nil
+}
+
+// fieldErrors holds the errors of encrypted fields that could not be decrypted. Models return it
+// only after all fields were read, so that decoding can go on after the value.
+type fieldErrors []error
+
+func (e fieldErrors) Error() string {
+	return errors.Join(e...).Error()
+}
+
+func (e fieldErrors) Unwrap() []error {
+	return e
+}
+
+// undecrypted reports whether a field failed to decode only because encrypted values in it could not
+// be decrypted, after the value was read.
+func undecrypted(t BuftiType, err error) bool {
+	switch t.(type) {
+	case encryptedType:
+		return errors.Is(err, ErrEncrypted)
+	case ReferenceType:
+		_, ok := err.(fieldErrors)
+		return ok
+	}
+	return false
+}
+
+// unread marks the errors of encrypted fields in a value that was not read to the end, such as
+// an element of a list, so that callers do not go on decoding after it.
+func unread(err error) error {
+	if _, ok := err.(fieldErrors); ok {
+		return errors.Join(err)
+	}
+	return err
+}
+
+// skipValue advances the buffer past a value of the given type without decoding it.
+func skipValue(buf *bytes.Buffer, t BuftiType) error {
+	switch t := t.(type) {
+	case SimpleType:
+		size := t.size()
+		if size == 0 {
+			length, err := readUint32(buf, "length")
+			if err != nil {
+				return fmt.Errorf("failed to decode %s: %w", t, err)
+			}
+			if length > uint32(buf.Len()) {
+				return fmt.Errorf("%w: %s length %d exceeds buffer size %d", ErrBuffer, t, length, buf.Len())
+			}
+			size = int(length)
+		}
+		if size > buf.Len() {
+			return fmt.Errorf("%w: failed to decode %s: %w", ErrBuffer, t, io.ErrUnexpectedEOF)
+		}
+		buf.Next(size)
+
+	case ListType:
+		length, err := readUint32(buf, "list length")
+		if err != nil {
+			return err
+		}
+		for range length {
+			if err := skipValue(buf, t.elementType); err != nil {
+				return err
+			}
+		}
+
+	case MapType:
+		length, err := readUint32(buf, "map length")
+		if err != nil {
+			return err
+		}
+		for range length {
+			if err := skipValue(buf, t.keyType); err != nil {
+				return err
+			}
+			if err := skipValue(buf, t.valueType); err != nil {
+				return err
+			}
+		}
+
+	case ReferenceType:
+		fieldCount, err := readUint32(buf, "field count")
+		if err != nil {
+			return err
+		}
+		for range fieldCount {
+			index, err := buf.ReadByte()
+			if err != nil {
+				return fmt.Errorf("%w: failed to read field index: %w", ErrBuffer, err)
+			}
+			schemaField, exists := t.model.schema[index]
+			if !exists {
+				return fmt.Errorf("%w: index %d does not exist on model %s", ErrBuffer, index, t.model.name)
+			}
+			if err := skipValue(buf, schemaField.fieldType); err != nil {
+				return err
+			}
+		}
+
+	case encryptedType:
+		return skipValue(buf, Bytes)
+
+	default:
+		var discard any
+		return t.Decode(buf, reflect.ValueOf(&discard).Elem())
+	}
 	return nil
 }
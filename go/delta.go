@@ -0,0 +1,248 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Operations that a delta applies to a changed field.
+const (
+	deltaRemove  byte = iota // the field is removed
+	deltaReplace             // the field is set to the encoded value that follows
+	deltaAdd                 // the integer field changes by the signed varint that follows
+	deltaNested              // the nested model changes by the delta that follows
+)
+
+// EncodeDelta encodes the changes from prev to next, two buffers encoded with the model, as a delta
+// that ApplyDelta turns back into next. Only changed fields are written: every model starts with a
+// bitmap with one bit per schema field in index order, followed by the changes of the marked fields.
+// Integer fields are written as the difference to their previous value when that is shorter,
+// nested models as a delta of their own, and all other fields in full.
+//
+// Returns ErrVersion if either buffer was encoded with an incompatible protocol version.
+// Returns ErrBuffer if either buffer is corrupted or cannot be parsed.
+func EncodeDelta(model *Model, prev, next []byte) ([]byte, error) {
+	old := make(map[string]any)
+	if err := model.Decode(prev, &old); err != nil {
+		return nil, err
+	}
+	updated := make(map[string]any)
+	if err := model.Decode(next, &updated); err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, ProtocolVersion|flagDelta); err != nil {
+		return nil, fmt.Errorf("failed to write protocol version")
+	}
+	if err := writeDelta(buf, model, old, updated); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeDelta(buf *bytes.Buffer, m *Model, old, updated map[string]any) error {
+	indices := m.indices()
+	bitmap := make([]byte, (len(indices)+7)/8)
+	changes := new(bytes.Buffer)
+
+	for i, index := range indices {
+		field := m.schema[index]
+		a, inOld := old[field.label]
+		b, inUpdated := updated[field.label]
+
+		switch {
+		case !inOld && !inUpdated:
+			continue
+		case !inUpdated:
+			changes.WriteByte(deltaRemove)
+		case !inOld:
+			changes.WriteByte(deltaReplace)
+			if err := field.fieldType.Encode(changes, reflect.ValueOf(b)); err != nil {
+				return err
+			}
+		default:
+			if reflect.DeepEqual(a, b) {
+				continue
+			}
+			if ref, ok := field.fieldType.(ReferenceType); ok {
+				changes.WriteByte(deltaNested)
+				if err := writeDelta(changes, ref.model, a.(map[string]any), b.(map[string]any)); err != nil {
+					return err
+				}
+				break
+			}
+			if t, ok := field.fieldType.(SimpleType); ok {
+				if diff, ok := integerDelta(a, b); ok && len(diff) < t.size() {
+					changes.WriteByte(deltaAdd)
+					changes.Write(diff)
+					break
+				}
+			}
+			changes.WriteByte(deltaReplace)
+			if err := field.fieldType.Encode(changes, reflect.ValueOf(b)); err != nil {
+				return err
+			}
+		}
+		bitmap[i/8] |= 1 << (i % 8)
+	}
+
+	buf.Write(bitmap)
+	buf.Write(changes.Bytes())
+	return nil
+}
+
+// ApplyDelta applies a delta created by EncodeDelta to prev, and returns the encoded result.
+// prev has to be the buffer the delta was created from, or hold the same values.
+//
+// Returns ErrVersion if prev or the delta was encoded with an incompatible protocol version.
+// Returns ErrBuffer if prev or the delta is corrupted, or the delta does not apply to prev.
+func ApplyDelta(model *Model, prev, delta []byte) ([]byte, error) {
+	fields := make(map[string]any)
+	if err := model.Decode(prev, &fields); err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(delta)
+	header, err := readUint32(buf, "delta header")
+	if err != nil {
+		return nil, err
+	}
+	if header != ProtocolVersion|flagDelta {
+		return nil, fmt.Errorf("%w: not a delta of butil version %d, header %#x", ErrVersion, ProtocolVersion, header)
+	}
+
+	if err := applyDelta(buf, model, fields); err != nil {
+		return nil, err
+	}
+	if buf.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d unexpected bytes after delta", ErrBuffer, buf.Len())
+	}
+	return model.Encode(fields)
+}
+
+func applyDelta(buf *bytes.Buffer, m *Model, fields map[string]any) error {
+	indices := m.indices()
+	bitmap := buf.Next((len(indices) + 7) / 8)
+	if len(bitmap) < (len(indices)+7)/8 {
+		return fmt.Errorf("%w: failed to read delta bitmap for model %s", ErrBuffer, m.name)
+	}
+
+	for i, index := range indices {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		field := m.schema[index]
+
+		op, err := buf.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: failed to read delta of field %s", ErrBuffer, field.label)
+		}
+
+		switch op {
+		case deltaRemove:
+			delete(fields, field.label)
+
+		case deltaReplace:
+			var value any
+			if err := field.fieldType.Decode(buf, reflect.ValueOf(&value).Elem()); err != nil {
+				return err
+			}
+			fields[field.label] = value
+
+		case deltaAdd:
+			t, _ := field.fieldType.(SimpleType)
+			bits, mask, ok := integerBits(fields[field.label])
+			if !ok {
+				return fmt.Errorf("%w: delta adds to field %s, which is not a set integer", ErrBuffer, field.label)
+			}
+			diff, err := binary.ReadVarint(buf)
+			if err != nil {
+				return fmt.Errorf("%w: failed to read delta of field %s: %w", ErrBuffer, field.label, err)
+			}
+			fields[field.label] = integerFromBits(t, (bits+uint64(diff))&mask)
+
+		case deltaNested:
+			ref, isReference := field.fieldType.(ReferenceType)
+			nested, isSet := fields[field.label].(map[string]any)
+			if !isReference || !isSet {
+				return fmt.Errorf("%w: delta changes field %s, which is not a set reference", ErrBuffer, field.label)
+			}
+			if err := applyDelta(buf, ref.model, nested); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("%w: unknown delta operation %d for field %s", ErrBuffer, op, field.label)
+		}
+	}
+	return nil
+}
+
+// integerDelta returns the difference from a to b as a signed varint, if both are integers.
+// The difference wraps around at the width of the type, so that it stays small in both directions.
+func integerDelta(a, b any) ([]byte, bool) {
+	x, mask, ok := integerBits(a)
+	if !ok {
+		return nil, false
+	}
+	y, _, ok := integerBits(b)
+	if !ok {
+		return nil, false
+	}
+
+	diff := (y - x) & mask
+	signed := int64(diff)
+	if mask != math.MaxUint64 && diff > mask>>1 {
+		signed = int64(diff) - int64(mask) - 1
+	}
+	return binary.AppendVarint(nil, signed), true
+}
+
+// integerBits returns the bits of an integer value and the mask of its width.
+func integerBits(v any) (uint64, uint64, bool) {
+	switch v := v.(type) {
+	case int8:
+		return uint64(uint8(v)), math.MaxUint8, true
+	case int16:
+		return uint64(uint16(v)), math.MaxUint16, true
+	case int32:
+		return uint64(uint32(v)), math.MaxUint32, true
+	case int64:
+		return uint64(v), math.MaxUint64, true
+	case uint8:
+		return uint64(v), math.MaxUint8, true
+	case uint16:
+		return uint64(v), math.MaxUint16, true
+	case uint32:
+		return uint64(v), math.MaxUint32, true
+	case uint64:
+		return v, math.MaxUint64, true
+	default:
+		return 0, 0, false
+	}
+}
+
+func integerFromBits(t SimpleType, bits uint64) any {
+	switch t {
+	case Int8:
+		return int8(bits)
+	case Int16:
+		return int16(bits)
+	case Int32:
+		return int32(bits)
+	case Int64:
+		return int64(bits)
+	case Uint8:
+		return uint8(bits)
+	case Uint16:
+		return uint16(bits)
+	case Uint32:
+		return uint32(bits)
+	default:
+		return bits
+	}
+}
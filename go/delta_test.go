@@ -0,0 +1,116 @@
+package butil
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	models, err := ParseSchema(projectionSchema)
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+
+	for _, model := range []*Model{simpleModel, complexModel, nestedModel, models["message"]} {
+		g := NewGenerator(3, nil)
+		for range 100 {
+			a, err := g.Map(model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := g.Map(model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prev, err := model.Encode(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err := model.Encode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, pair := range [][2][]byte{{prev, next}, {prev, prev}, {next, prev}} {
+				delta, err := EncodeDelta(model, pair[0], pair[1])
+				if err != nil {
+					t.Fatalf("EncodeDelta failed: %v", err)
+				}
+				applied, err := ApplyDelta(model, pair[0], delta)
+				if err != nil {
+					t.Fatalf("ApplyDelta failed: %v", err)
+				}
+				if !bytes.Equal(applied, pair[1]) {
+					t.Fatalf("Expected %x, got %x", pair[1], applied)
+				}
+			}
+		}
+	}
+}
+
+func TestDeltaSize(t *testing.T) {
+	prev, err := nestedModel.Encode(NestedStruct{
+		ID:       1000,
+		Simple:   SimpleStruct{ID: 1, Name: "player", Age: 20, Rate: 1.5},
+		Children: []SimpleStruct{{ID: 2}, {ID: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := nestedModel.Encode(NestedStruct{
+		ID:       999,
+		Simple:   SimpleStruct{ID: 1, Name: "player", Age: 21, Rate: 1.5},
+		Children: []SimpleStruct{{ID: 2}, {ID: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delta, err := EncodeDelta(nestedModel, prev, next)
+	if err != nil {
+		t.Fatalf("EncodeDelta failed: %v", err)
+	}
+
+	// header, bitmap, id -1, nested bitmap, age +1
+	expected := []byte{1, 0, 1, 0, 0b011, deltaAdd, 1, deltaNested, 0b0100, deltaAdd, 2}
+	if !bytes.Equal(delta, expected) {
+		t.Errorf("Expected %x, got %x", expected, delta)
+	}
+}
+
+func TestDeltaErrors(t *testing.T) {
+	prev, err := simpleModel.Encode(map[string]any{"id": int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := simpleModel.Encode(map[string]any{"id": int64(2), "name": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := EncodeDelta(simpleModel, prev, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := simpleModel.Decode(delta, &map[string]any{}); !errors.Is(err, ErrVersion) {
+		t.Errorf("Expected Decode to reject a delta with ErrVersion, got %v", err)
+	}
+	if _, err := ApplyDelta(simpleModel, prev, next); !errors.Is(err, ErrVersion) {
+		t.Errorf("Expected ErrVersion for a message, got %v", err)
+	}
+	if _, err := ApplyDelta(simpleModel, prev, delta[:len(delta)-1]); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a truncated delta, got %v", err)
+	}
+	if _, err := ApplyDelta(simpleModel, prev, append(delta, 0)); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for trailing bytes, got %v", err)
+	}
+
+	empty, err := simpleModel.Encode(map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ApplyDelta(simpleModel, empty, delta); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a delta that does not apply, got %v", err)
+	}
+}
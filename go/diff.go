@@ -0,0 +1,226 @@
+package butil
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// Change describes a value that differs between two buffers.
+type Change struct {
+	// Path locates the value, written as accepted by Get, for example `user.tags[2]` or `scores["a"]`.
+	Path string
+	// Old is the value in the first buffer, or nil if it is not set there.
+	Old any
+	// New is the value in the second buffer, or nil if it is not set there.
+	New any
+}
+
+// Diff compares two buffers encoded with the model and returns the values that differ.
+// Nested models are compared field by field, lists element by element and maps entry by entry,
+// so every change is reported at the deepest path at which it occurs. A list that grows or
+// shrinks reports each added or removed element. Values are decoded as by Decode into an interface.
+// Changes are ordered by field index, list index and map key.
+//
+// Returns ErrVersion if either buffer was encoded with an incompatible protocol version.
+// Returns ErrBuffer if either buffer is corrupted or cannot be parsed.
+func Diff(model *Model, a, b []byte) ([]Change, error) {
+	old := make(map[string]any)
+	if err := model.Decode(a, &old); err != nil {
+		return nil, err
+	}
+	updated := make(map[string]any)
+	if err := model.Decode(b, &updated); err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	diffFields(&changes, "", model, old, updated)
+	return changes, nil
+}
+
+func diffFields(changes *[]Change, prefix string, m *Model, a, b map[string]any) {
+	for _, index := range m.indices() {
+		field := m.schema[index]
+		path := field.label
+		if prefix != "" {
+			path = prefix + "." + field.label
+		}
+
+		old, inOld := a[field.label]
+		updated, inUpdated := b[field.label]
+		if inOld && inUpdated {
+			diffValue(changes, path, field.fieldType, old, updated)
+		} else if inOld || inUpdated {
+			*changes = append(*changes, Change{Path: path, Old: old, New: updated})
+		}
+	}
+}
+
+func diffValue(changes *[]Change, path string, t BuftiType, a, b any) {
+	switch t := t.(type) {
+	case ReferenceType:
+		diffFields(changes, path, t.model, a.(map[string]any), b.(map[string]any))
+
+	case ListType:
+		old, updated := a.([]any), b.([]any)
+		for i := range max(len(old), len(updated)) {
+			elementPath := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(old):
+				*changes = append(*changes, Change{Path: elementPath, New: updated[i]})
+			case i >= len(updated):
+				*changes = append(*changes, Change{Path: elementPath, Old: old[i]})
+			default:
+				diffValue(changes, elementPath, t.elementType, old[i], updated[i])
+			}
+		}
+
+	case MapType:
+		old, updated := reflect.ValueOf(a), reflect.ValueOf(b)
+		keys := old.MapKeys()
+		for _, key := range updated.MapKeys() {
+			if !old.MapIndex(key).IsValid() {
+				keys = append(keys, key)
+			}
+		}
+		slices.SortFunc(keys, compareKeys)
+
+		for _, key := range keys {
+			entryPath := path + "[" + formatScalar(key.Interface()) + "]"
+			oldValue, updatedValue := old.MapIndex(key), updated.MapIndex(key)
+			switch {
+			case !oldValue.IsValid():
+				*changes = append(*changes, Change{Path: entryPath, New: updatedValue.Interface()})
+			case !updatedValue.IsValid():
+				*changes = append(*changes, Change{Path: entryPath, Old: oldValue.Interface()})
+			default:
+				diffValue(changes, entryPath, t.valueType, oldValue.Interface(), updatedValue.Interface())
+			}
+		}
+
+	default:
+		if !reflect.DeepEqual(a, b) {
+			*changes = append(*changes, Change{Path: path, Old: a, New: b})
+		}
+	}
+}
+
+// MergeOptions configures how Merge combines two buffers.
+type MergeOptions struct {
+	// ReplaceLists replaces lists of the base with lists of the overlay instead of appending to them.
+	ReplaceLists bool
+}
+
+// Merge applies the fields that are set in overlay onto base, and returns the encoded result.
+// Scalar fields of the overlay replace those of the base, nested models are merged field by field,
+// lists are appended to unless options.ReplaceLists is set, and map entries of the overlay are added
+// to the map of the base, replacing entries with the same key. Fields that are not set in the
+// overlay are kept from the base. If options is nil, the defaults are used.
+//
+// The overlay only needs to hold the fields it changes: required fields are not checked for the overlay,
+// but for the merged result.
+//
+// Returns ErrVersion if either buffer was encoded with an incompatible protocol version.
+// Returns ErrBuffer if either buffer is corrupted or cannot be parsed.
+// Returns ErrInput if a required field is missing from the merged result.
+func Merge(model *Model, base, overlay []byte, options *MergeOptions) ([]byte, error) {
+	if options == nil {
+		options = &MergeOptions{}
+	}
+
+	merged := make(map[string]any)
+	if err := model.Decode(base, &merged); err != nil {
+		return nil, err
+	}
+	fields, err := decodeOverlay(model, overlay)
+	if err != nil {
+		return nil, err
+	}
+
+	mergeFields(model, merged, fields, options)
+	return model.Encode(merged)
+}
+
+// decodeOverlay decodes a buffer into a map like Decode, but without checking for required fields,
+// in the buffer and in the models it references.
+func decodeOverlay(m *Model, data []byte) (map[string]any, error) {
+	plain, err := unwrap(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(plain)
+	if err := readVersion(buf); err != nil {
+		return nil, err
+	}
+	return decodeSparse(m, buf)
+}
+
+func decodeSparse(m *Model, buf *bytes.Buffer) (map[string]any, error) {
+	fieldCount, err := readUint32(buf, "field count")
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]any)
+	for range fieldCount {
+		index, err := buf.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read field index: %w", ErrBuffer, err)
+		}
+		field, exists := m.schema[index]
+		if !exists {
+			return nil, fmt.Errorf("%w: index %d does not exist on model %s", ErrBuffer, index, m.name)
+		}
+
+		var value any
+		if ref, ok := field.fieldType.(ReferenceType); ok {
+			if value, err = decodeSparse(ref.model, buf); err != nil {
+				return nil, err
+			}
+		} else if err := field.fieldType.Decode(buf, reflect.ValueOf(&value).Elem()); err != nil {
+			return nil, err
+		}
+		fields[field.label] = value
+	}
+	return fields, nil
+}
+
+func mergeFields(m *Model, base, overlay map[string]any, options *MergeOptions) {
+	for label, value := range overlay {
+		current, exists := base[label]
+		if !exists {
+			base[label] = value
+			continue
+		}
+		base[label] = mergeValue(m.schema[m.labels[label]].fieldType, current, value, options)
+	}
+}
+
+func mergeValue(t BuftiType, base, overlay any, options *MergeOptions) any {
+	switch t := t.(type) {
+	case ReferenceType:
+		fields := base.(map[string]any)
+		mergeFields(t.model, fields, overlay.(map[string]any), options)
+		return fields
+
+	case ListType:
+		if options.ReplaceLists {
+			return overlay
+		}
+		return append(base.([]any), overlay.([]any)...)
+
+	case MapType:
+		entries := reflect.ValueOf(base)
+		iter := reflect.ValueOf(overlay).MapRange()
+		for iter.Next() {
+			entries.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return base
+
+	default:
+		return overlay
+	}
+}
@@ -0,0 +1,215 @@
+package butil
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	message := projectionModels(t)["message"]
+
+	old, err := message.Encode(map[string]any{
+		"id":      int64(1),
+		"payload": []byte{1},
+		"user": map[string]any{
+			"id":      int64(2),
+			"name":    "ada",
+			"tags":    []any{"a", "b"},
+			"address": map[string]any{"city": "Berlin"},
+			"scores":  map[uint8]any{1: []any{0.5}, 2: []any{}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := message.Encode(map[string]any{
+		"id": int64(1),
+		"user": map[string]any{
+			"id":      int64(2),
+			"name":    "grace",
+			"tags":    []any{"a", "c", "d"},
+			"address": map[string]any{"city": "Berlin", "zip code": uint32(10115)},
+			"scores":  map[uint8]any{1: []any{0.75}, 3: []any{}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Diff(message, old, updated)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+
+	expected := []Change{
+		{Path: "payload", Old: []byte{1}},
+		{Path: "user.name", Old: "ada", New: "grace"},
+		{Path: "user.tags[1]", Old: "b", New: "c"},
+		{Path: "user.tags[2]", New: "d"},
+		{Path: "user.address.zip code", New: uint32(10115)},
+		{Path: "user.scores[1][0]", Old: 0.5, New: 0.75},
+		{Path: "user.scores[2]", Old: []any{}},
+		{Path: "user.scores[3]", New: []any{}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+
+	// Every path of a change can be read back with Get.
+	for _, change := range changes {
+		if change.New == nil {
+			continue
+		}
+		value, _, err := Get(message, updated, change.Path)
+		if err != nil {
+			t.Errorf("%s: Get failed: %v", change.Path, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, change.New) {
+			t.Errorf("%s: expected %v, got %v", change.Path, change.New, value)
+		}
+	}
+
+	same, err := Diff(message, old, old)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if len(same) != 0 {
+		t.Errorf("Expected no changes, got %v", same)
+	}
+
+	if _, err := Diff(message, old, updated[:10]); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer, got %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	message := projectionModels(t)["message"]
+
+	base, err := message.Encode(map[string]any{
+		"id":      int64(1),
+		"payload": []byte{1},
+		"user": map[string]any{
+			"id":      int64(2),
+			"name":    "ada",
+			"tags":    []any{"a"},
+			"address": map[string]any{"city": "Berlin", "zip code": uint32(10115)},
+			"scores":  map[uint8]any{1: []any{0.5}, 2: []any{1.0}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := message.Encode(map[string]any{
+		"id": int64(3),
+		"user": map[string]any{
+			"id":      int64(2),
+			"name":    "grace",
+			"tags":    []any{"b"},
+			"address": map[string]any{"city": "Paris"},
+			"scores":  map[uint8]any{2: []any{2.0}, 3: []any{}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		options  *MergeOptions
+		expected []any
+	}{
+		{name: "append", options: nil, expected: []any{"a", "b"}},
+		{name: "replace", options: &MergeOptions{ReplaceLists: true}, expected: []any{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := Merge(message, base, overlay, tt.options)
+			if err != nil {
+				t.Fatalf("Merge failed: %v", err)
+			}
+
+			decoded := make(map[string]any)
+			if err := message.Decode(merged, &decoded); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			expected := map[string]any{
+				"id":      int64(3),
+				"payload": []byte{1},
+				"user": map[string]any{
+					"id":      int64(2),
+					"name":    "grace",
+					"tags":    tt.expected,
+					"address": map[string]any{"city": "Paris", "zip code": uint32(10115)},
+					"scores":  map[uint8]any{1: []any{0.5}, 2: []any{2.0}, 3: []any{}},
+				},
+			}
+			if !reflect.DeepEqual(decoded, expected) {
+				t.Errorf("Expected %v, got %v", expected, decoded)
+			}
+		})
+	}
+}
+
+func TestMergeRequiredFields(t *testing.T) {
+	owner := newModelWithOptions(&ModelOptions{Name: "owner", RequiredByDefault: true},
+		Field(0, "id", Int64),
+		Field(1, "name", String),
+	)
+	counter := newModelWithOptions(&ModelOptions{Name: "counter", RequiredByDefault: true},
+		Field(0, "id", Int64),
+		Field(1, "name", String),
+		OptionalField(2, "count", Uint32),
+		OptionalField(3, "owner", Reference(owner)),
+	)
+	// The overlay is encoded with a model that has the same fields, all optional.
+	sparseOwner := newModelWithOptions(&ModelOptions{Name: "owner"},
+		Field(0, "id", Int64),
+		Field(1, "name", String),
+	)
+	sparse := newModelWithOptions(&ModelOptions{Name: "counter"},
+		Field(0, "id", Int64),
+		Field(1, "name", String),
+		Field(2, "count", Uint32),
+		Field(3, "owner", Reference(sparseOwner)),
+	)
+
+	base, err := counter.Encode(map[string]any{
+		"id": int64(1), "name": "visits", "count": uint32(1),
+		"owner": map[string]any{"id": int64(7), "name": "ada"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := sparse.Encode(map[string]any{"count": uint32(2), "owner": map[string]any{"name": "grace"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := Merge(counter, base, overlay, nil)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	decoded := make(map[string]any)
+	if err := counter.Decode(merged, &decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	expected := map[string]any{
+		"id": int64(1), "name": "visits", "count": uint32(2),
+		"owner": map[string]any{"id": int64(7), "name": "grace"},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %v, got %v", expected, decoded)
+	}
+
+	// Required fields are checked on the merged result, whose owner has no id without one in the base.
+	ownerless, err := counter.Encode(map[string]any{"id": int64(1), "name": "visits"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Merge(counter, ownerless, overlay, nil); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for a merged result without required fields, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// dumpRowSize is the number of bytes shown per line of a dump.
+const dumpRowSize = 8
+
+// Dump writes an annotated hex dump of an encoded buffer to w.
+// It walks the buffer the same way Decode does and prints every byte range with its offset
+// and meaning: the version header, field counts, field indices, length prefixes,
+// list and map elements and the decoded values.
+//
+// If the buffer cannot be walked, the remaining bytes are printed as unparsed and the
+// returned error names the offset and the field at which decoding failed.
+func Dump(model *Model, data []byte, w io.Writer) error {
+	d := &dumper{w: w, data: data, buf: bytes.NewBuffer(data)}
+
+	err := d.dumpMessage(model)
+	if err != nil {
+		d.printf("%06x  !! %v\n", d.failedAt, err)
+		d.line(d.failedAt, len(data), 0, "unparsed")
+		err = fmt.Errorf("at offset %#x in field %s: %w", d.failedAt, d.fieldPath(), err)
+	} else if d.buf.Len() > 0 {
+		d.line(d.offset(), len(data), 0, "trailing data")
+	}
+
+	if d.writeErr != nil {
+		return d.writeErr
+	}
+	return err
+}
+
+type dumper struct {
+	w        io.Writer
+	data     []byte
+	buf      *bytes.Buffer
+	path     []string
+	failedAt int
+	writeErr error
+}
+
+func (d *dumper) offset() int {
+	return len(d.data) - d.buf.Len()
+}
+
+func (d *dumper) fieldPath() string {
+	if len(d.path) == 0 {
+		return "<header>"
+	}
+	return strings.TrimPrefix(strings.Join(d.path, ""), ".")
+}
+
+func (d *dumper) printf(format string, args ...any) {
+	if d.writeErr != nil {
+		return
+	}
+	_, d.writeErr = fmt.Fprintf(d.w, format, args...)
+}
+
+// line prints the bytes between start and end, annotating the first row with the description.
+func (d *dumper) line(start, end, depth int, format string, args ...any) {
+	description := strings.Repeat("  ", depth) + fmt.Sprintf(format, args...)
+
+	for row := start; row < end || row == start; row += dumpRowSize {
+		rowEnd := min(row+dumpRowSize, end)
+
+		hex := make([]string, 0, dumpRowSize)
+		for _, b := range d.data[row:rowEnd] {
+			hex = append(hex, fmt.Sprintf("%02x", b))
+		}
+
+		if row == start {
+			d.printf("%06x  %-*s  %s\n", row, dumpRowSize*3-1, strings.Join(hex, " "), description)
+		} else {
+			d.printf("%06x  %s\n", row, strings.Join(hex, " "))
+		}
+	}
+}
+
+// fail records the offset at which reading started before returning err.
+func (d *dumper) fail(start int, err error) error {
+	d.failedAt = start
+	return err
+}
+
+func (d *dumper) readLength(depth int, format string, args ...any) (uint32, error) {
+	start := d.offset()
+	var length uint32
+	if err := binary.Read(d.buf, binary.LittleEndian, &length); err != nil {
+		return 0, d.fail(start, fmt.Errorf("%w: failed to read %s: %w", ErrBuffer, fmt.Sprintf(format, args...), err))
+	}
+	d.line(start, d.offset(), depth, "%s %d", fmt.Sprintf(format, args...), length)
+	return length, nil
+}
+
+func (d *dumper) dumpMessage(m *Model) error {
+	start := d.offset()
+	var version uint32
+	if err := binary.Read(d.buf, binary.LittleEndian, &version); err != nil {
+		return d.fail(start, fmt.Errorf("%w: failed to read protocol version", ErrBuffer))
+	}
+	d.line(start, d.offset(), 0, "version %d", version)
+	if version != ProtocolVersion {
+		return d.fail(start, fmt.Errorf("%w: incompatible butil version: this package uses version %d, buffer uses version %d", ErrVersion, ProtocolVersion, version))
+	}
+
+	return d.dumpFields(m, "", 0)
+}
+
+func (d *dumper) dumpFields(m *Model, prefix string, depth int) error {
+	fieldCount, err := d.readLength(depth, "%sfield count (model %s)", prefix, m.name)
+	if err != nil {
+		return err
+	}
+
+	for range fieldCount {
+		start := d.offset()
+		index, err := d.buf.ReadByte()
+		if err != nil {
+			return d.fail(start, fmt.Errorf("%w: failed to read field index: %w", ErrBuffer, err))
+		}
+
+		schemaField, exists := m.schema[index]
+		if !exists {
+			return d.fail(start, fmt.Errorf("%w: index %d does not exist on model %s", ErrBuffer, index, m.name))
+		}
+		d.line(start, d.offset(), depth, "field %d %s (%v)", index, schemaField.label, schemaField.fieldType)
+
+		d.path = append(d.path, "."+schemaField.label)
+		if err := d.dumpValue(schemaField.fieldType, "", depth+1); err != nil {
+			return err
+		}
+		d.path = d.path[:len(d.path)-1]
+	}
+	return nil
+}
+
+func (d *dumper) dumpValue(t BuftiType, prefix string, depth int) error {
+	switch t := t.(type) {
+	case SimpleType:
+		if t == String || t == Bytes {
+			length, err := d.readLength(depth, "%slength", prefix)
+			if err != nil {
+				return err
+			}
+
+			start := d.offset()
+			if uint32(d.buf.Len()) < length {
+				return d.fail(start, fmt.Errorf("%w: %s length %d exceeds buffer size %d", ErrBuffer, t, length, d.buf.Len()))
+			}
+			content := d.buf.Next(int(length))
+			if length == 0 {
+				return nil
+			}
+			if t == String {
+				d.line(start, d.offset(), depth, "%s", formatScalar(string(content)))
+			} else {
+				d.line(start, d.offset(), depth, "%s", formatScalar(content))
+			}
+			return nil
+		}
+
+		start := d.offset()
+		var v any
+		if err := t.Decode(d.buf, reflect.ValueOf(&v).Elem()); err != nil {
+			return d.fail(start, err)
+		}
+		d.line(start, d.offset(), depth, "%s%s", prefix, formatScalar(v))
+
+	case ListType:
+		length, err := d.readLength(depth, "%slist length", prefix)
+		if err != nil {
+			return err
+		}
+		for i := range length {
+			d.path = append(d.path, fmt.Sprintf("[%d]", i))
+			if err := d.dumpValue(t.elementType, fmt.Sprintf("[%d] ", i), depth+1); err != nil {
+				return err
+			}
+			d.path = d.path[:len(d.path)-1]
+		}
+
+	case MapType:
+		length, err := d.readLength(depth, "%smap length", prefix)
+		if err != nil {
+			return err
+		}
+		for i := range length {
+			d.path = append(d.path, fmt.Sprintf("[%d]", i))
+			if err := d.dumpValue(t.keyType, fmt.Sprintf("[%d] key ", i), depth+1); err != nil {
+				return err
+			}
+			if err := d.dumpValue(t.valueType, fmt.Sprintf("[%d] value ", i), depth+1); err != nil {
+				return err
+			}
+			d.path = d.path[:len(d.path)-1]
+		}
+
+	case ReferenceType:
+		return d.dumpFields(t.model, prefix, depth)
+
+	case encryptedType:
+		return d.dumpValue(Bytes, prefix+"encrypted ", depth)
+
+	default:
+		return d.fail(d.offset(), fmt.Errorf("%w: cannot dump values of type %T", ErrModel, t))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package butil
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	model := newModel(
+		Field(0, "id", Int16),
+		Field(1, "tags", List(String)),
+	)
+
+	encoded, err := Parse(model, `id: 7 tags: ["ab"]`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var sb strings.Builder
+	if err := Dump(model, encoded, &sb); err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+
+	expected := `000000  01 00 00 00              version 1
+000004  02 00 00 00              field count (model unnamed_model) 2
+000008  00                       field 0 id (butil int16)
+000009  07 00                      7
+00000b  01                       field 1 tags (butil list of butil strings)
+00000c  01 00 00 00                list length 1
+000010  02 00 00 00                  [0] length 2
+000014  61 62                        "ab"
+`
+	if sb.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, sb.String())
+	}
+}
+
+func TestDumpTruncated(t *testing.T) {
+	encoded, err := Parse(nestedModel, `id: 1 children: [{name: "x"}, {age: 3}]`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var sb strings.Builder
+	err = Dump(nestedModel, encoded[:len(encoded)-2], &sb)
+	if !errors.Is(err, ErrBuffer) {
+		t.Fatalf("Expected ErrBuffer, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "offset 0x25 in field children[1].age") {
+		t.Errorf("Expected error to name offset and field, got %v", err)
+	}
+	if !strings.Contains(sb.String(), "000025  03 00") {
+		t.Errorf("Expected unparsed bytes in dump, got\n%s", sb.String())
+	}
+}
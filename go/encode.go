@@ -10,6 +10,8 @@ import (
 // Encode serializes the given data according to the model schema.
 // The data can be a struct or map[string]any. Struct fields are mapped to
 // schema fields using either the field name or the `butil` tag.
+// Fields are written in ascending index order and map entries in ascending key order,
+// so equal values always encode to equal bytes.
 //
 // Returns ErrInput if the data is nil or of an unsupported type.
 // Returns ErrModel if required fields are missing or schema validation fails.
@@ -61,39 +63,48 @@ type valueFieldPair struct {
 	field ModelField
 }
 
-func (m *Model) encodeStruct(buf *bytes.Buffer, t reflect.Type, v reflect.Value) error {
+// structFields returns the index of every exported struct field by its label.
+// The label is the field name or the `butil` tag. Results are cached per type.
+func (m *Model) structFields(t reflect.Type) map[string]int {
 	m.mu.RLock()
 	fieldMap, exists := m.fieldCache[t]
 	m.mu.RUnlock()
 
-	if !exists {
-		fieldMap = make(map[string]reflect.Value, len(m.schema))
-
-		for i := 0; i < v.NumField(); i++ {
-			field := t.Field(i)
-			value := v.Field(i)
+	if exists {
+		return fieldMap
+	}
 
-			if !value.CanInterface() {
-				continue
-			}
+	fieldMap = make(map[string]int, len(m.schema))
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
 
-			fieldName := field.Name
-			if tag := field.Tag.Get("butil"); tag != "" {
-				fieldName = tag
-			}
-			fieldMap[fieldName] = value
+		if !field.IsExported() {
+			continue
 		}
 
-		m.mu.Lock()
-		if m.fieldCache == nil {
-			m.fieldCache = make(map[reflect.Type]map[string]reflect.Value)
+		fieldName := field.Name
+		if tag := field.Tag.Get("butil"); tag != "" {
+			fieldName = tag
 		}
-		m.fieldCache[t] = fieldMap
-		m.mu.Unlock()
+		fieldMap[fieldName] = i
 	}
 
+	m.mu.Lock()
+	if m.fieldCache == nil {
+		m.fieldCache = make(map[reflect.Type]map[string]int)
+	}
+	m.fieldCache[t] = fieldMap
+	m.mu.Unlock()
+	return fieldMap
+}
+
+func (m *Model) encodeStruct(buf *bytes.Buffer, t reflect.Type, v reflect.Value) error {
+	fieldMap := m.structFields(t)
+
 	valueFieldPairs := make(map[byte]valueFieldPair, len(m.schema))
-	for fieldName, value := range fieldMap {
+	for fieldName, i := range fieldMap {
+		value := v.Field(i)
+
 		index, exists := m.labels[fieldName]
 		if !exists {
 			return fmt.Errorf("%w: field %s not found in model %s", ErrInput, fieldName, m.name)
@@ -123,17 +134,7 @@ func (m *Model) encodeStruct(buf *bytes.Buffer, t reflect.Type, v reflect.Value)
 		return err
 	}
 
-	for index, pair := range valueFieldPairs {
-		if err := buf.WriteByte(index); err != nil {
-			return err
-		}
-
-		if err := pair.field.fieldType.Encode(buf, pair.v); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return writeFields(buf, m, valueFieldPairs)
 }
 
 func (m *Model) encodeMap(buf *bytes.Buffer, _ reflect.Type, v reflect.Value) error {
@@ -177,7 +178,17 @@ func (m *Model) encodeMap(buf *bytes.Buffer, _ reflect.Type, v reflect.Value) er
 		return err
 	}
 
-	for index, pair := range fieldMap {
+	return writeFields(buf, m, fieldMap)
+}
+
+// writeFields writes the given fields in ascending index order, so that equal values always encode to equal bytes.
+func writeFields(buf *bytes.Buffer, m *Model, fields map[byte]valueFieldPair) error {
+	for _, index := range m.indices() {
+		pair, exists := fields[index]
+		if !exists {
+			continue
+		}
+
 		if err := buf.WriteByte(index); err != nil {
 			return err
 		}
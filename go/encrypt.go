@@ -0,0 +1,222 @@
+package butil
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// EncryptedField creates a new model field whose value is encrypted with the field keys of the model.
+// The field is required by default unless the model is configured otherwise.
+//
+// An encrypted value is written as bytes holding the key ID, a nonce and the AES-GCM encrypted value.
+// The nonce is derived from the value, so encoding stays deterministic, at the cost of revealing
+// which encrypted values of a field are equal. Buffers stay readable to holders of the model that
+// do not have field keys: Decode stores an EncryptedValue for the field when decoding into an
+// interface or a field of that type. Other destinations are left unset, and Decode returns a
+// FieldError wrapping ErrEncrypted for them after decoding the other fields. Encoding an
+// EncryptedValue writes it back unchanged.
+func EncryptedField(index byte, label string, fieldType BuftiType) ModelField {
+	return ModelField{
+		index:     index,
+		label:     label,
+		fieldType: fieldType,
+		encrypted: true,
+	}
+}
+
+// SetFieldKeys sets the keys that the values of encrypted fields of the model are encrypted and
+// decrypted with. Values are encrypted with the key with the given ID, and decrypted with the key
+// whose ID they hold. Models referenced by the model have keys of their own.
+func (m *Model) SetFieldKeys(keys KeyProvider, keyID uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fieldKeys = keys
+	m.fieldKeyID = keyID
+}
+
+func (m *Model) getFieldKeys() (KeyProvider, uint32) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.fieldKeys, m.fieldKeyID
+}
+
+// EncryptedValue is the value of an encrypted field that was decoded without its key.
+// It holds the key ID, nonce and encrypted value as they were read from the buffer.
+type EncryptedValue []byte
+
+var encryptedValueType = reflect.TypeOf(EncryptedValue(nil))
+
+// FieldError is an error of a single field of a model. Decode returns FieldErrors wrapping ErrEncrypted
+// for encrypted fields that it could not decrypt after decoding the other fields. They can be found
+// in the returned error with errors.As.
+type FieldError struct {
+	Model string
+	Label string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s of model %s: %v", e.Label, e.Model, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// KeyID returns the ID of the key the value is encrypted with.
+func (v EncryptedValue) KeyID() uint32 {
+	if len(v) < 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(v)
+}
+
+// MarshalJSON writes the value as an object with the base64 encoded bytes under "encrypted",
+// which FromJSON writes back unchanged.
+func (v EncryptedValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string][]byte{"encrypted": v})
+}
+
+// encryptedType is the type of an encrypted field. It is created by the model for fields
+// created with EncryptedField, and wraps the declared type of the field.
+type encryptedType struct {
+	fieldType BuftiType
+	model     *Model
+	index     byte
+}
+
+func (t encryptedType) String() string {
+	return fmt.Sprintf("encrypted %v", t.fieldType)
+}
+
+// additionalData binds an encrypted value to its key ID and field index, so that encrypted values
+// cannot be moved between fields.
+func (t encryptedType) additionalData(keyID []byte) []byte {
+	return append(bytes.Clone(keyID), t.index)
+}
+
+func (t encryptedType) Encode(buf *bytes.Buffer, val reflect.Value) error {
+	if val.Kind() == reflect.Interface {
+		val = val.Elem()
+	}
+	if val.IsValid() && val.Type() == encryptedValueType {
+		return t.writeSealed(buf, val.Bytes())
+	}
+
+	plain := new(bytes.Buffer)
+	if err := t.fieldType.Encode(plain, val); err != nil {
+		return err
+	}
+	return t.encrypt(buf, plain.Bytes())
+}
+
+// encrypt writes an encoded value encrypted with the current field key of the model.
+func (t encryptedType) encrypt(buf *bytes.Buffer, plain []byte) error {
+	keys, keyID := t.model.getFieldKeys()
+	if keys == nil {
+		return fmt.Errorf("%w: field %d of model %s is encrypted, but the model has no field keys", ErrInput, t.index, t.model.name)
+	}
+	key, err := keys.Key(keyID)
+	if err != nil {
+		return fmt.Errorf("%w: failed to get key %d: %w", ErrInput, keyID, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInput, err)
+	}
+
+	sealed := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+aead.NonceSize()+len(plain)+aead.Overhead()), keyID)
+	nonce := t.nonce(key, sealed[:4], plain)[:aead.NonceSize()]
+	sealed = aead.Seal(append(sealed, nonce...), nonce, plain, t.additionalData(sealed[:4]))
+	return t.writeSealed(buf, sealed)
+}
+
+// nonce derives the nonce of a value from the key, key ID, field index and plaintext, like AES-GCM-SIV
+// derives its IV, so that equal values encrypt to equal bytes and encoding stays deterministic.
+// Different values get different nonces, so a nonce is never reused for different plaintexts,
+// but holders of the buffers can see which values of a field are equal.
+func (t encryptedType) nonce(key, keyID, plain []byte) []byte {
+	derive := hmac.New(sha256.New, key)
+	derive.Write([]byte("butil field nonce"))
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write(t.additionalData(keyID))
+	mac.Write(plain)
+	return mac.Sum(nil)
+}
+
+func (t encryptedType) writeSealed(buf *bytes.Buffer, sealed []byte) error {
+	if len(sealed) < 4 {
+		return fmt.Errorf("%w: encrypted value is too short", ErrInput)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(sealed))); err != nil {
+		return err
+	}
+	_, err := buf.Write(sealed)
+	return err
+}
+
+// Decode decrypts the value into val. If the model has no field keys, an EncryptedValue is stored in
+// interface and EncryptedValue destinations. Other destinations are left unchanged, and a FieldError
+// wrapping ErrEncrypted is returned after the value was read, as it is if the key cannot be found.
+func (t encryptedType) Decode(buf *bytes.Buffer, val reflect.Value) error {
+	length, err := readUint32(buf, "encrypted value length")
+	if err != nil {
+		return err
+	}
+	if length < 4 || length > uint32(buf.Len()) {
+		return fmt.Errorf("%w: invalid encrypted value length %d", ErrBuffer, length)
+	}
+	sealed := buf.Next(int(length))
+
+	if val.Type() == encryptedValueType {
+		val.Set(reflect.ValueOf(EncryptedValue(bytes.Clone(sealed))))
+		return nil
+	}
+	keys, _ := t.model.getFieldKeys()
+	if keys == nil {
+		if val.Kind() == reflect.Interface {
+			val.Set(reflect.ValueOf(EncryptedValue(bytes.Clone(sealed))))
+			return nil
+		}
+		return t.fieldError(fmt.Errorf("%w: the model has no field keys", ErrEncrypted))
+	}
+	keyID := binary.LittleEndian.Uint32(sealed)
+	key, err := keys.Key(keyID)
+	if err != nil {
+		if val.Kind() == reflect.Interface {
+			val.Set(reflect.ValueOf(EncryptedValue(bytes.Clone(sealed))))
+		}
+		return t.fieldError(fmt.Errorf("%w: failed to get key %d: %w", ErrEncrypted, keyID, err))
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuthentication, err)
+	}
+	if len(sealed) < 4+aead.NonceSize()+aead.Overhead() {
+		return fmt.Errorf("%w: encrypted value is too short", ErrBuffer)
+	}
+	nonce := sealed[4 : 4+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, sealed[4+aead.NonceSize():], t.additionalData(sealed[:4]))
+	if err != nil {
+		return fmt.Errorf("%w: field %d of model %s: %w", ErrAuthentication, t.index, t.model.name, err)
+	}
+
+	inner := bytes.NewBuffer(plain)
+	if err := t.fieldType.Decode(inner, val); err != nil {
+		return err
+	}
+	if inner.Len() != 0 {
+		return fmt.Errorf("%w: %d unexpected bytes after encrypted %s", ErrBuffer, inner.Len(), t.fieldType)
+	}
+	return nil
+}
+
+func (t encryptedType) fieldError(err error) error {
+	return &FieldError{Model: t.model.name, Label: t.model.schema[t.index].label, Err: err}
+}
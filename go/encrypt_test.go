@@ -0,0 +1,273 @@
+package butil
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type Account struct {
+	ID   int64  `butil:"id"`
+	Name string `butil:"name"`
+	SSN  string `butil:"ssn"`
+	Card []byte `butil:"card"`
+}
+
+type OpaqueAccount struct {
+	ID   int64          `butil:"id"`
+	Name string         `butil:"name"`
+	SSN  EncryptedValue `butil:"ssn"`
+}
+
+func accountModel(keys KeyProvider, keyID uint32) *Model {
+	m := newModelWithOptions(
+		&ModelOptions{Name: "account"},
+		Field(0, "id", Int64),
+		Field(1, "name", String),
+		EncryptedField(2, "ssn", String),
+		EncryptedField(3, "card", Bytes),
+	)
+	if keys != nil {
+		m.SetFieldKeys(keys, keyID)
+	}
+	return m
+}
+
+func TestEncryptedField(t *testing.T) {
+	keyed := accountModel(testKeys, 1)
+	original := Account{ID: 1, Name: "ada", SSN: "078-05-1120", Card: []byte("4111111111111111")}
+
+	data, err := keyed.Encode(original)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if bytes.Contains(data, []byte(original.SSN)) || bytes.Contains(data, original.Card) {
+		t.Error("Expected encrypted fields to be unreadable")
+	}
+	if !bytes.Contains(data, []byte(original.Name)) {
+		t.Error("Expected other fields to stay readable")
+	}
+
+	var decoded Account
+	if err := keyed.Decode(data, &decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("Expected %+v, got %+v", original, decoded)
+	}
+
+	// A model without the key decodes the other fields, and reports the fields it cannot decrypt.
+	unkeyed := accountModel(nil, 0)
+	var partial Account
+	err = unkeyed.Decode(data, &partial)
+	if !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted without key, got %v", err)
+	}
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Model != "account" || fieldErr.Label != "ssn" {
+		t.Errorf("Expected a FieldError for ssn, got %v", err)
+	}
+	if expected := (Account{ID: 1, Name: "ada"}); !reflect.DeepEqual(partial, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, partial)
+	}
+
+	var opaque OpaqueAccount
+	if err := unkeyed.Decode(data, &opaque); err != nil {
+		t.Fatalf("Decode into EncryptedValue failed: %v", err)
+	}
+	if opaque.SSN == nil || opaque.SSN.KeyID() != 1 {
+		t.Errorf("Expected an EncryptedValue with key 1, got %v", opaque.SSN)
+	}
+
+	fields := make(map[string]any)
+	if err := unkeyed.Decode(data, &fields); err != nil {
+		t.Fatalf("Decode into map failed: %v", err)
+	}
+	if _, ok := fields["card"].(EncryptedValue); !ok {
+		t.Errorf("Expected an EncryptedValue placeholder, got %T", fields["card"])
+	}
+
+	// Placeholders are written back unchanged, so the keyed model can still decrypt them.
+	fields["name"] = "grace"
+	updated, err := unkeyed.Encode(fields)
+	if err != nil {
+		t.Fatalf("Encode of placeholders failed: %v", err)
+	}
+	decoded = Account{}
+	if err := keyed.Decode(updated, &decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	original.Name = "grace"
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("Expected %+v, got %+v", original, decoded)
+	}
+
+	// Values encrypted with a rotated key are decrypted with the key they hold.
+	rotated := accountModel(testKeys, 2)
+	data, err = rotated.Encode(original)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded = Account{}
+	if err := keyed.Decode(data, &decoded); err != nil {
+		t.Fatalf("Decode of rotated key failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("Expected %+v, got %+v", original, decoded)
+	}
+}
+
+func TestEncryptedFieldErrors(t *testing.T) {
+	keyed := accountModel(testKeys, 1)
+	data, err := keyed.Encode(Account{ID: 1, SSN: "078-05-1120"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-1] ^= 1
+	var decoded Account
+	if err := keyed.Decode(tampered, &decoded); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Expected ErrAuthentication, got %v", err)
+	}
+
+	// Encrypted values cannot be moved to another encrypted field.
+	fields := make(map[string]any)
+	if err := accountModel(nil, 0).Decode(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["card"], fields["ssn"] = fields["ssn"], fields["card"]
+	swapped, err := keyed.Encode(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyed.Decode(swapped, &decoded); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Expected ErrAuthentication for swapped values, got %v", err)
+	}
+
+	// A key that cannot be found is reported, instead of being treated like a model without keys.
+	rotated, err := accountModel(testKeys, 2).Encode(Account{ID: 1, SSN: "078-05-1120"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded = Account{}
+	err = accountModel(StaticKeys{1: testKeys[1]}, 1).Decode(rotated, &decoded)
+	if !errors.Is(err, ErrEncrypted) || !strings.Contains(err.Error(), "unknown key 2") {
+		t.Errorf("Expected ErrEncrypted with the key provider error, got %v", err)
+	}
+	if decoded.ID != 1 {
+		t.Errorf("Expected the other fields to be decoded, got %+v", decoded)
+	}
+
+	if _, err := accountModel(nil, 0).Encode(Account{SSN: "x"}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput without keys, got %v", err)
+	}
+	if _, err := accountModel(testKeys, 3).Encode(Account{SSN: "x"}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unknown key, got %v", err)
+	}
+	if err := keyed.Decode(data[:len(data)-2], &decoded); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a truncated buffer, got %v", err)
+	}
+}
+
+func TestEncryptedFieldFormats(t *testing.T) {
+	keyed := accountModel(testKeys, 1)
+	unkeyed := accountModel(nil, 0)
+	data, err := keyed.Encode(Account{ID: 1, Name: "ada", SSN: "078-05-1120", Card: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := Format(unkeyed, data)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	if !strings.Contains(text, "ssn: encrypted b\"") {
+		t.Errorf("Expected the encrypted bytes in the text, got %q", text)
+	}
+	parsed, err := Parse(unkeyed, text)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !bytes.Equal(parsed, data) {
+		t.Error("Expected Parse to reproduce the buffer")
+	}
+
+	parsed, err = Parse(keyed, `id: 2, name: "x", ssn: "secret", card: b"\x01"`)
+	if err != nil {
+		t.Fatalf("Parse of plain values failed: %v", err)
+	}
+	var decoded Account
+	if err := keyed.Decode(parsed, &decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.SSN != "secret" {
+		t.Errorf("Expected the parsed value to be encrypted, got %q", decoded.SSN)
+	}
+
+	encoded, err := ToJSON(unkeyed, data)
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	transcoded, err := FromJSON(unkeyed, encoded)
+	if err != nil {
+		t.Fatalf("FromJSON failed: %v", err)
+	}
+	if !bytes.Equal(transcoded, data) {
+		t.Error("Expected FromJSON to reproduce the buffer")
+	}
+
+	var dump strings.Builder
+	if err := Dump(unkeyed, data, &dump); err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	if !strings.Contains(dump.String(), "encrypted length") {
+		t.Errorf("Expected the dump to mark encrypted fields, got:\n%s", dump.String())
+	}
+
+	schema := FormatSchema(keyed)
+	if !strings.Contains(schema, "encrypted 2 ssn: string") {
+		t.Errorf("Expected an encrypted field in the schema, got:\n%s", schema)
+	}
+	models, err := ParseSchema(schema)
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+	if models["account"].Fingerprint() != keyed.Fingerprint() {
+		t.Error("Expected the parsed schema to have the same fingerprint")
+	}
+	if _, ok := models["account"].schema[3].fieldType.(encryptedType); !ok {
+		t.Error("Expected card to be parsed as an encrypted field")
+	}
+}
+
+func TestEncryptedFieldNested(t *testing.T) {
+	holderModel := func(account *Model) *Model {
+		return newModelWithOptions(
+			&ModelOptions{Name: "holder"},
+			Field(0, "account", Reference(account)),
+			Field(1, "note", String),
+		)
+	}
+	type Holder struct {
+		Account Account `butil:"account"`
+		Note    string  `butil:"note"`
+	}
+
+	data, err := holderModel(accountModel(testKeys, 1)).Encode(Holder{Account: Account{ID: 7, SSN: "078-05-1120"}, Note: "after"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields after a referenced model with fields that cannot be decrypted are still decoded.
+	var decoded Holder
+	err = holderModel(accountModel(nil, 0)).Decode(data, &decoded)
+	if !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted without key, got %v", err)
+	}
+	if decoded.Account.ID != 7 || decoded.Note != "after" {
+		t.Errorf("Expected the other fields to be decoded, got %+v", decoded)
+	}
+}
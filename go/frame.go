@@ -0,0 +1,260 @@
+package butil
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// FrameLength is the format that frame lengths are written in.
+type FrameLength int
+
+const (
+	// Uint32Length writes frame lengths as fixed size little-endian uint32 values.
+	Uint32Length FrameLength = iota
+	// VarintLength writes frame lengths as unsigned varints, as in encoding/binary and
+	// length-delimited protocol buffers.
+	VarintLength
+)
+
+func (l FrameLength) String() string {
+	switch l {
+	case Uint32Length:
+		return "uint32"
+	case VarintLength:
+		return "varint"
+	default:
+		return fmt.Sprintf("FrameLength(%d)", int(l))
+	}
+}
+
+// DefaultMaxFrameSize is the largest frame that is read or written if FrameOptions.MaxFrameSize is not set.
+const DefaultMaxFrameSize = 64 << 20
+
+// FrameOptions configures how frames are written and read. Both ends of a stream have to use the same options.
+type FrameOptions struct {
+	// Length is the format of the length that precedes every frame.
+	Length FrameLength
+	// MaxFrameSize is the largest frame that is accepted. If it is 0, DefaultMaxFrameSize is used.
+	MaxFrameSize int
+	// Checksum appends the CRC-32C checksum of every frame as a little-endian uint32.
+	Checksum bool
+}
+
+func (o *FrameOptions) maxFrameSize() int {
+	if o.MaxFrameSize > 0 {
+		return o.MaxFrameSize
+	}
+	return DefaultMaxFrameSize
+}
+
+// WriteFrame writes data as a single frame: its length, the data and, if enabled, its checksum.
+// If options is nil, the defaults are used.
+//
+// Returns ErrInput if data is larger than the maximum frame size or the length format is unknown.
+func WriteFrame(w io.Writer, data []byte, options *FrameOptions) error {
+	if options == nil {
+		options = &FrameOptions{}
+	}
+	if len(data) > options.maxFrameSize() {
+		return fmt.Errorf("%w: frame of %d bytes exceeds maximum frame size %d", ErrInput, len(data), options.maxFrameSize())
+	}
+
+	var prefix []byte
+	switch options.Length {
+	case Uint32Length:
+		prefix = binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	case VarintLength:
+		prefix = binary.AppendUvarint(nil, uint64(len(data)))
+	default:
+		return fmt.Errorf("%w: unknown frame length %s", ErrInput, options.Length)
+	}
+
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if options.Checksum {
+		_, err := w.Write(binary.LittleEndian.AppendUint32(nil, crc32.Checksum(data, castagnoli)))
+		return err
+	}
+	return nil
+}
+
+// ReadFrame reads a single frame written by WriteFrame and returns its data.
+// If options is nil, the defaults are used. Varint lengths are read one byte at a time
+// unless r implements io.ByteReader, so readers should be buffered.
+//
+// Returns io.EOF if r ends before the frame starts.
+// Returns ErrBuffer if the frame is truncated or larger than the maximum frame size.
+// Returns ErrChecksum if the checksum of the frame does not match.
+func ReadFrame(r io.Reader, options *FrameOptions) ([]byte, error) {
+	if options == nil {
+		options = &FrameOptions{}
+	}
+
+	var length uint64
+	switch options.Length {
+	case Uint32Length:
+		var prefix [4]byte
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			return nil, frameError(err, "frame length")
+		}
+		length = uint64(binary.LittleEndian.Uint32(prefix[:]))
+	case VarintLength:
+		byteReader, ok := r.(io.ByteReader)
+		if !ok {
+			byteReader = singleByteReader{r}
+		}
+		var err error
+		if length, err = readFrameVarint(byteReader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown frame length %s", ErrInput, options.Length)
+	}
+
+	if length > uint64(options.maxFrameSize()) {
+		return nil, fmt.Errorf("%w: frame of %d bytes exceeds maximum frame size %d", ErrBuffer, length, options.maxFrameSize())
+	}
+
+	size := int(length)
+	if options.Checksum {
+		size += 4
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, frameError(noEOF(err), "frame")
+	}
+
+	if options.Checksum {
+		data := frame[:length]
+		expected := binary.LittleEndian.Uint32(frame[length:])
+		if actual := crc32.Checksum(data, castagnoli); actual != expected {
+			return nil, fmt.Errorf("%w: frame checksum expected %08x, got %08x", ErrChecksum, expected, actual)
+		}
+		return data, nil
+	}
+	return frame, nil
+}
+
+// frameError wraps errors of reading a frame. io.EOF is returned unchanged, so that the end
+// of a stream can be told apart from a truncated frame.
+func frameError(err error, what string) error {
+	if err == io.EOF {
+		return err
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: failed to read %s: %w", ErrBuffer, what, err)
+	}
+	return fmt.Errorf("failed to read %s: %w", what, err)
+}
+
+// readFrameVarint reads a varint frame length. Lengths are at most five bytes long, which is enough
+// for any frame that fits in memory.
+func readFrameVarint(r io.ByteReader) (uint64, error) {
+	var length uint64
+	for shift := 0; ; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			if shift > 0 {
+				err = noEOF(err)
+			}
+			return 0, frameError(err, "frame length")
+		}
+		if shift == 35 {
+			return 0, fmt.Errorf("%w: frame length varint is too long", ErrBuffer)
+		}
+		length |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return length, nil
+		}
+	}
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for reads that start in the middle of a frame.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// singleByteReader reads varints from readers that do not implement io.ByteReader.
+type singleByteReader struct {
+	io.Reader
+}
+
+func (r singleByteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
+
+// FrameWriter writes a stream of framed messages encoded with a model.
+type FrameWriter struct {
+	w       io.Writer
+	model   *Model
+	options *FrameOptions
+}
+
+// NewFrameWriter returns a FrameWriter that encodes messages with the model and writes them to w.
+// If options is nil, the defaults are used.
+func NewFrameWriter(w io.Writer, model *Model, options *FrameOptions) *FrameWriter {
+	if options == nil {
+		options = &FrameOptions{}
+	}
+	return &FrameWriter{w: w, model: model, options: options}
+}
+
+// Encode encodes data with the model of the writer and writes it as a frame.
+// It accepts the same inputs as Model.Encode.
+func (w *FrameWriter) Encode(data any) error {
+	encoded, err := w.model.Encode(data)
+	if err != nil {
+		return err
+	}
+	return w.WriteFrame(encoded)
+}
+
+// WriteFrame writes an already encoded message as a frame, for example one encoded with EncodeWithOptions.
+func (w *FrameWriter) WriteFrame(data []byte) error {
+	return WriteFrame(w.w, data, w.options)
+}
+
+// FrameReader reads a stream of framed messages encoded with a model.
+type FrameReader struct {
+	r       *bufio.Reader
+	model   *Model
+	options *FrameOptions
+}
+
+// NewFrameReader returns a FrameReader that reads frames from r and decodes them with the model.
+// Reads from r are buffered, so r should not be read from directly afterwards.
+// If options is nil, the defaults are used.
+func NewFrameReader(r io.Reader, model *Model, options *FrameOptions) *FrameReader {
+	if options == nil {
+		options = &FrameOptions{}
+	}
+	return &FrameReader{r: bufio.NewReader(r), model: model, options: options}
+}
+
+// Decode reads the next frame and decodes it with the model of the reader into dest.
+// It accepts the same destinations as Model.Decode, and returns io.EOF at the end of the stream.
+func (r *FrameReader) Decode(dest any) error {
+	frame, err := r.ReadFrame()
+	if err != nil {
+		return err
+	}
+	return r.model.Decode(frame, dest)
+}
+
+// ReadFrame reads the next frame without decoding it, and returns io.EOF at the end of the stream.
+func (r *FrameReader) ReadFrame() ([]byte, error) {
+	return ReadFrame(r.r, r.options)
+}
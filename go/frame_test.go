@@ -0,0 +1,120 @@
+package butil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFrames(t *testing.T) {
+	messages := []SimpleStruct{
+		{ID: 1, Name: "first"},
+		{ID: 2, Name: string(bytes.Repeat([]byte("x"), 300))},
+		{},
+	}
+
+	tests := []struct {
+		name    string
+		options *FrameOptions
+	}{
+		{name: "default", options: nil},
+		{name: "varint", options: &FrameOptions{Length: VarintLength}},
+		{name: "checksum", options: &FrameOptions{Checksum: true}},
+		{name: "varint_checksum", options: &FrameOptions{Length: VarintLength, Checksum: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream bytes.Buffer
+			w := NewFrameWriter(&stream, simpleModel, tt.options)
+			for _, message := range messages {
+				if err := w.Encode(message); err != nil {
+					t.Fatalf("Encode failed: %v", err)
+				}
+			}
+
+			// Reading one byte at a time must not change the result.
+			r := NewFrameReader(iotest.OneByteReader(bytes.NewReader(stream.Bytes())), simpleModel, tt.options)
+			for i, expected := range messages {
+				var decoded SimpleStruct
+				if err := r.Decode(&decoded); err != nil {
+					t.Fatalf("Decode of frame %d failed: %v", i, err)
+				}
+				if !reflect.DeepEqual(decoded, expected) {
+					t.Errorf("Frame %d: expected %+v, got %+v", i, expected, decoded)
+				}
+			}
+			var decoded SimpleStruct
+			if err := r.Decode(&decoded); err != io.EOF {
+				t.Errorf("Expected io.EOF at the end of the stream, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFrameFormat(t *testing.T) {
+	var stream bytes.Buffer
+	if err := WriteFrame(&stream, []byte("abc"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFrame(&stream, bytes.Repeat([]byte{1}, 200), &FrameOptions{Length: VarintLength}); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []byte{3, 0, 0, 0, 'a', 'b', 'c', 0xc8, 0x01}; !bytes.Equal(stream.Bytes()[:9], expected) {
+		t.Errorf("Expected prefix %v, got %v", expected, stream.Bytes()[:9])
+	}
+
+	frame, err := ReadFrame(&stream, nil)
+	if err != nil || string(frame) != "abc" {
+		t.Errorf("Expected abc, got %q, %v", frame, err)
+	}
+	frame, err = ReadFrame(&stream, &FrameOptions{Length: VarintLength})
+	if err != nil || len(frame) != 200 {
+		t.Errorf("Expected 200 bytes, got %d, %v", len(frame), err)
+	}
+}
+
+func TestFrameErrors(t *testing.T) {
+	options := &FrameOptions{MaxFrameSize: 16, Checksum: true}
+
+	if err := WriteFrame(io.Discard, make([]byte, 17), options); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an oversized frame, got %v", err)
+	}
+	if err := WriteFrame(io.Discard, nil, &FrameOptions{Length: 7}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unknown length format, got %v", err)
+	}
+
+	var stream bytes.Buffer
+	if err := WriteFrame(&stream, []byte("frame"), options); err != nil {
+		t.Fatal(err)
+	}
+	valid := stream.Bytes()
+
+	corrupted := bytes.Clone(valid)
+	corrupted[5] ^= 1
+	if _, err := ReadFrame(bytes.NewReader(corrupted), options); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Expected ErrChecksum, got %v", err)
+	}
+
+	for _, size := range []int{2, 6, len(valid) - 1} {
+		if _, err := ReadFrame(bytes.NewReader(valid[:size]), options); !errors.Is(err, ErrBuffer) {
+			t.Errorf("Truncated to %d: expected ErrBuffer, got %v", size, err)
+		}
+	}
+
+	oversized := []byte{17, 0, 0, 0}
+	if _, err := ReadFrame(bytes.NewReader(oversized), options); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for an oversized frame, got %v", err)
+	}
+	overlong := []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x01}
+	if _, err := ReadFrame(bytes.NewReader(overlong), &FrameOptions{Length: VarintLength}); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for an overlong varint, got %v", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(nil), nil); err != io.EOF {
+		t.Errorf("Expected io.EOF for an empty stream, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package butil
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzTargets returns the test models along with constructors for their destinations.
+func fuzzTargets() []struct {
+	name  string
+	model *Model
+	dest  func() any
+} {
+	return []struct {
+		name  string
+		model *Model
+		dest  func() any
+	}{
+		{name: "simple_struct", model: simpleModel, dest: func() any { return &SimpleStruct{} }},
+		{name: "simple_map", model: simpleModel, dest: func() any { return &map[string]any{} }},
+		{name: "complex_struct", model: complexModel, dest: func() any { return &ComplexStruct{} }},
+		{name: "complex_map", model: complexModel, dest: func() any { return &map[string]any{} }},
+		{name: "nested_struct", model: nestedModel, dest: func() any { return &NestedStruct{} }},
+		{name: "nested_map", model: nestedModel, dest: func() any { return &map[string]any{} }},
+	}
+}
+
+func addFuzzSeeds(f *testing.F) {
+	seeds := []struct {
+		model *Model
+		text  string
+	}{
+		{model: simpleModel, text: `id: 1 name: "a" age: 2 rate: 0.5`},
+		{model: complexModel, text: `id: 1 tags: ["a", ""] scores: [1.5] metadata: {"k": 2} active: true data: b"\x00"`},
+		{model: nestedModel, text: `id: 1 simple: {name: "x"} children: [{id: 2}, {age: 3}]`},
+	}
+	for _, seed := range seeds {
+		encoded, err := Parse(seed.model, seed.text)
+		if err != nil {
+			f.Fatalf("Invalid seed %q: %v", seed.text, err)
+		}
+		f.Add(encoded)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{1, 0, 0, 0, 255, 255, 255, 255})
+}
+
+// FuzzDecode checks that decoding arbitrary input into any destination fails gracefully instead of panicking.
+func FuzzDecode(f *testing.F) {
+	addFuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, target := range fuzzTargets() {
+			_ = target.model.Decode(data, target.dest())
+		}
+	})
+}
+
+// FuzzRoundTrip checks that every buffer that decodes successfully encodes again, to a stable result.
+func FuzzRoundTrip(f *testing.F) {
+	addFuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, target := range fuzzTargets() {
+			first := target.dest()
+			if err := target.model.Decode(data, first); err != nil {
+				continue
+			}
+			// Whatever the decoder accepts, the encoder has to accept as well.
+			encoded, err := target.model.Encode(first)
+			if err != nil {
+				t.Fatalf("%s: Encode of decoded buffer failed: %v", target.name, err)
+			}
+
+			second := target.dest()
+			if err := target.model.Decode(encoded, second); err != nil {
+				t.Fatalf("%s: Decode of re-encoded buffer failed: %v", target.name, err)
+			}
+			reencoded, err := target.model.Encode(second)
+			if err != nil {
+				t.Fatalf("%s: Encode of decoded buffer failed: %v", target.name, err)
+			}
+			if !bytes.Equal(encoded, reencoded) {
+				t.Fatalf("%s: encoding is not stable\nfirst  %x\nsecond %x", target.name, encoded, reencoded)
+			}
+		}
+	})
+}
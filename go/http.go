@@ -0,0 +1,167 @@
+package butil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MediaType is the media type of encoded buffers in HTTP bodies.
+const MediaType = "application/x-bufti"
+
+// DefaultMaxBodySize is the largest request body that DecodeRequest reads.
+const DefaultMaxBodySize = 4 << 20
+
+// DecodeRequest decodes the body of an HTTP request into dest according to the model schema.
+// Bodies of MediaType, or without a content type, are decoded as by Decode, and JSON bodies as by FromJSON.
+// At most DefaultMaxBodySize bytes are read.
+//
+// Returns ErrInput if the content type is not supported, the body is larger than the limit or the JSON
+// does not match the model. Otherwise returns the errors of Decode.
+func DecodeRequest(r *http.Request, model *Model, dest any) error {
+	return DecodeRequestLimit(r, model, dest, DefaultMaxBodySize)
+}
+
+// DecodeRequestLimit is like DecodeRequest, but reads at most limit bytes of the body.
+func DecodeRequestLimit(r *http.Request, model *Model, dest any, limit int64) error {
+	mediaType := MediaType
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("%w: invalid content type %q", ErrInput, contentType)
+		}
+	}
+	if mediaType != MediaType && mediaType != "application/json" {
+		return fmt.Errorf("%w: unsupported content type %s", ErrInput, mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return fmt.Errorf("%w: failed to read body: %w", ErrInput, err)
+	}
+	if int64(len(body)) > limit {
+		return fmt.Errorf("%w: body exceeds %d bytes", ErrInput, limit)
+	}
+
+	if mediaType == "application/json" {
+		if body, err = FromJSON(model, body); err != nil {
+			return err
+		}
+	}
+	return model.Decode(body, dest)
+}
+
+// WriteResponse encodes v with the model and writes it as the body of a response of MediaType.
+//
+// Returns the errors of Encode, in which case nothing is written.
+func WriteResponse(w http.ResponseWriter, model *Model, v any) error {
+	return writeBody(w, model, v, MediaType)
+}
+
+// WriteNegotiated is like WriteResponse, but writes the body in the media type that Negotiate chose
+// for the request, as by ToJSON for JSON. Requests that did not pass through Negotiate get MediaType.
+//
+// Returns the errors of Encode and ToJSON, in which case nothing is written.
+func WriteNegotiated(w http.ResponseWriter, r *http.Request, model *Model, v any) error {
+	return writeBody(w, model, v, NegotiatedMediaType(r))
+}
+
+func writeBody(w http.ResponseWriter, model *Model, v any, mediaType string) error {
+	encoded, err := model.Encode(v)
+	if err != nil {
+		return err
+	}
+	if mediaType == "application/json" {
+		if encoded, err = ToJSON(model, encoded); err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	_, err = w.Write(encoded)
+	return err
+}
+
+type negotiatedKey struct{}
+
+// NegotiatedMediaType returns the media type that Negotiate chose for the response to a request,
+// which is MediaType or "application/json". It returns MediaType if the request did not pass through Negotiate.
+func NegotiatedMediaType(r *http.Request) string {
+	if mediaType, ok := r.Context().Value(negotiatedKey{}).(string); ok {
+		return mediaType
+	}
+	return MediaType
+}
+
+// Negotiate is middleware that chooses between MediaType and JSON responses by the Accept header
+// of the request, for bodies written with WriteNegotiated. The choice is stored in the context of
+// the request, so it reaches the handler through other middleware. MediaType is preferred when both
+// are equally acceptable or the request has no Accept header. Requests that accept neither are
+// answered with 406 Not Acceptable.
+func Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		mediaType := MediaType
+		if accept := r.Header.Values("Accept"); len(accept) != 0 {
+			bufti := acceptQuality(accept, MediaType)
+			json := acceptQuality(accept, "application/json")
+			if bufti == 0 && json == 0 {
+				http.Error(w, fmt.Sprintf("acceptable media types are %s and application/json", MediaType), http.StatusNotAcceptable)
+				return
+			}
+			if json > bufti {
+				mediaType = "application/json"
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), negotiatedKey{}, mediaType)))
+	})
+}
+
+// acceptQuality returns the quality that Accept header values give a media type, using the most
+// specific matching range. It returns 0 if the media type is not acceptable.
+func acceptQuality(accept []string, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+
+	for _, value := range accept {
+		for _, entry := range strings.Split(value, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			accepted, params, err := mime.ParseMediaType(entry)
+			if err != nil {
+				continue
+			}
+
+			var s int
+			switch accepted {
+			case mediaType:
+				s = 2
+			case typ + "/*":
+				s = 1
+			case "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s < specificity {
+				continue
+			}
+
+			q := 1.0
+			if value, exists := params["q"]; exists {
+				if q, err = strconv.ParseFloat(value, 64); err != nil || q < 0 || q > 1 {
+					continue
+				}
+			}
+			quality, specificity = q, s
+		}
+	}
+	return quality
+}
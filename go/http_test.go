@@ -0,0 +1,189 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	expected := SimpleStruct{ID: 7, Name: "bob", Age: 30, Rate: 0.5}
+	encoded, err := simpleModel.Encode(expected)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{name: "bufti", contentType: MediaType, body: encoded},
+		{name: "no_content_type", body: encoded},
+		{name: "json", contentType: "application/json; charset=utf-8", body: []byte(`{"id": 7, "name": "bob", "age": 30, "rate": 0.5}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			var actual SimpleStruct
+			if err := DecodeRequest(r, simpleModel, &actual); err != nil {
+				t.Fatalf("DecodeRequest failed: %v", err)
+			}
+			if actual != expected {
+				t.Errorf("Expected %+v, got %+v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestDecodeRequestErrors(t *testing.T) {
+	encoded, err := simpleModel.Encode(SimpleStruct{Name: strings.Repeat("x", 100)})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+	var dest SimpleStruct
+	if err := DecodeRequestLimit(r, simpleModel, &dest, 50); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for a body over the limit, got %v", err)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+	r.Header.Set("Content-Type", "text/plain")
+	if err := DecodeRequest(r, simpleModel, &dest); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unsupported content type, got %v", err)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"id": "seven"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if err := DecodeRequest(r, simpleModel, &dest); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for JSON that does not match the model, got %v", err)
+	}
+}
+
+// recordingWriter stands for middleware that wraps the response writer, such as logging.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func TestNegotiate(t *testing.T) {
+	value := SimpleStruct{ID: 1, Name: "alice"}
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := WriteNegotiated(w, r, simpleModel, value); err != nil {
+			t.Errorf("WriteNegotiated failed: %v", err)
+		}
+	})
+	handlers := map[string]http.Handler{
+		"direct": Negotiate(inner),
+		// Middleware between Negotiate and the handler replaces the response writer.
+		"wrapped": Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inner.ServeHTTP(&recordingWriter{ResponseWriter: w}, r)
+		})),
+		"timeout": Negotiate(http.TimeoutHandler(inner, time.Minute, "timeout")),
+	}
+
+	tests := []struct {
+		name        string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{name: "no_accept", status: http.StatusOK, contentType: MediaType},
+		{name: "bufti", accept: MediaType, status: http.StatusOK, contentType: MediaType},
+		{name: "json", accept: "application/json", status: http.StatusOK, contentType: "application/json"},
+		{name: "any", accept: "*/*", status: http.StatusOK, contentType: MediaType},
+		{name: "json_preferred", accept: MediaType + ";q=0.5, application/json", status: http.StatusOK, contentType: "application/json"},
+		{name: "json_excluded", accept: "application/*, application/json;q=0", status: http.StatusOK, contentType: MediaType},
+		{name: "not_acceptable", accept: "text/html", status: http.StatusNotAcceptable},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				if tt.accept != "" {
+					r.Header.Set("Accept", tt.accept)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+
+				if w.Code != tt.status {
+					t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+				}
+				if w.Header().Get("Vary") != "Accept" {
+					t.Errorf("Expected Vary: Accept, got %q", w.Header().Get("Vary"))
+				}
+				if tt.status != http.StatusOK {
+					return
+				}
+				if contentType := w.Header().Get("Content-Type"); contentType != tt.contentType {
+					t.Fatalf("Expected content type %s, got %s", tt.contentType, contentType)
+				}
+
+				var actual SimpleStruct
+				if tt.contentType == MediaType {
+					if err := simpleModel.Decode(w.Body.Bytes(), &actual); err != nil {
+						t.Fatalf("Decode failed: %v", err)
+					}
+				} else {
+					var fields map[string]any
+					if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+						t.Fatalf("Response is not JSON: %v", err)
+					}
+					if fields["name"] != "alice" {
+						t.Fatalf("Expected name alice, got %v", fields["name"])
+					}
+					actual = value
+				}
+				if actual != value {
+					t.Errorf("Expected %+v, got %+v", value, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestWriteResponseWithoutNegotiate(t *testing.T) {
+	// Without Negotiate, WriteNegotiated writes MediaType even to clients that prefer JSON.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	if err := WriteNegotiated(w, r, simpleModel, SimpleStruct{ID: 3}); err != nil {
+		t.Fatalf("WriteNegotiated failed: %v", err)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != MediaType {
+		t.Errorf("Expected content type %s, got %s", MediaType, contentType)
+	}
+
+	var actual SimpleStruct
+	if err := simpleModel.Decode(w.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if actual.ID != 3 {
+		t.Errorf("Expected ID 3, got %d", actual.ID)
+	}
+
+	w = httptest.NewRecorder()
+	if err := WriteResponse(w, simpleModel, SimpleStruct{ID: 3}); err != nil {
+		t.Fatalf("WriteResponse failed: %v", err)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != MediaType {
+		t.Errorf("Expected content type %s, got %s", MediaType, contentType)
+	}
+}
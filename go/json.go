@@ -0,0 +1,303 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// ToJSON transcodes an encoded buffer to JSON according to the model schema.
+// Nested models become objects, lists become arrays, maps become objects keyed by the
+// string form of their keys and bytes become base64 strings. Floats that are NaN or infinite
+// become the strings "NaN", "+Inf" and "-Inf", which JSON numbers cannot express. Encrypted fields
+// that the model has no key for become objects that hold their encrypted bytes, as written by EncryptedValue.
+//
+// Returns ErrVersion if the data was encoded with an incompatible protocol version.
+// Returns ErrBuffer if the data is corrupted or cannot be parsed.
+func ToJSON(model *Model, data []byte) ([]byte, error) {
+	fields := make(map[string]any)
+	if err := model.Decode(data, &fields); err != nil {
+		return nil, err
+	}
+
+	result, err := json.Marshal(toJSONValue(Reference(model), fields))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBuffer, err)
+	}
+	return result, nil
+}
+
+// toJSONValue converts a decoded value into one that encoding/json can represent, in the form
+// that fromJSONValue reads back.
+func toJSONValue(t BuftiType, value any) any {
+	switch t := t.(type) {
+	case SimpleType:
+		switch v := value.(type) {
+		case float32:
+			if f := float64(v); math.IsNaN(f) || math.IsInf(f, 0) {
+				return strconv.FormatFloat(f, 'g', -1, 32)
+			}
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return strconv.FormatFloat(v, 'g', -1, 64)
+			}
+		}
+		return value
+
+	case ListType:
+		elements, ok := value.([]any)
+		if !ok {
+			return value
+		}
+		list := make([]any, len(elements))
+		for i, element := range elements {
+			list[i] = toJSONValue(t.elementType, element)
+		}
+		return list
+
+	case MapType:
+		entries := reflect.ValueOf(value)
+		if entries.Kind() != reflect.Map {
+			return value
+		}
+		object := make(map[string]any, entries.Len())
+		iter := entries.MapRange()
+		for iter.Next() {
+			object[jsonKey(iter.Key().Interface())] = toJSONValue(t.valueType, iter.Value().Interface())
+		}
+		return object
+
+	case ReferenceType:
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return value
+		}
+		object := make(map[string]any, len(fields))
+		for label, field := range fields {
+			object[label] = toJSONValue(t.model.schema[t.model.labels[label]].fieldType, field)
+		}
+		return object
+
+	case encryptedType:
+		if _, ok := value.(EncryptedValue); ok {
+			return value
+		}
+		return toJSONValue(t.fieldType, value)
+
+	default:
+		return value
+	}
+}
+
+// jsonKey formats a map key as fromJSONScalar parses it.
+func jsonKey(key any) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case bool:
+		return strconv.FormatBool(k)
+	case float32:
+		return strconv.FormatFloat(float64(k), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(k, 'g', -1, 64)
+	default:
+		return fmt.Sprint(k)
+	}
+}
+
+// FromJSON encodes a JSON object according to the model schema.
+// It accepts the representation produced by ToJSON.
+//
+// Returns ErrInput if the JSON is malformed, does not match the model schema or misses required fields.
+func FromJSON(model *Model, data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInput, err)
+	}
+
+	fields, err := fromJSONValue(Reference(model), value)
+	if err != nil {
+		return nil, err
+	}
+	return model.Encode(fields)
+}
+
+// fromJSONValue converts a value produced by encoding/json into the Go value the type encodes.
+func fromJSONValue(t BuftiType, value any) (any, error) {
+	switch t := t.(type) {
+	case SimpleType:
+		return fromJSONScalar(t, value)
+
+	case ListType:
+		elements, ok := value.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected array for %s, got %T", ErrInput, t, value)
+		}
+		list := make([]any, len(elements))
+		for i, element := range elements {
+			v, err := fromJSONValue(t.elementType, element)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+
+	case MapType:
+		entries, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected object for %s, got %T", ErrInput, t, value)
+		}
+		keyType, err := t.keyType.reflectType()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrModel, err)
+		}
+
+		result := reflect.MakeMapWithSize(reflect.MapOf(keyType, reflect.TypeOf((*any)(nil)).Elem()), len(entries))
+		for key, entry := range entries {
+			k, err := fromJSONScalar(t.keyType, json.Number(key))
+			if err != nil {
+				return nil, err
+			}
+			v, err := fromJSONValue(t.valueType, entry)
+			if err != nil {
+				return nil, err
+			}
+			result.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(&v).Elem())
+		}
+		return result.Interface(), nil
+
+	case ReferenceType:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected object for %s, got %T", ErrInput, t, value)
+		}
+		fields := make(map[string]any, len(object))
+		for label, entry := range object {
+			index, exists := t.model.labels[label]
+			if !exists {
+				return nil, fmt.Errorf("%w: field %s not found in model %s", ErrInput, label, t.model.name)
+			}
+			v, err := fromJSONValue(t.model.schema[index].fieldType, entry)
+			if err != nil {
+				return nil, err
+			}
+			fields[label] = v
+		}
+		return fields, nil
+
+	case encryptedType:
+		// Values that ToJSON could not decrypt are written back unchanged.
+		if object, ok := value.(map[string]any); ok && len(object) == 1 {
+			if sealed, ok := object["encrypted"].(string); ok {
+				b, err := base64.StdEncoding.DecodeString(sealed)
+				if err != nil {
+					return nil, fmt.Errorf("%w: invalid base64 for %s: %w", ErrInput, t, err)
+				}
+				return EncryptedValue(b), nil
+			}
+		}
+		return fromJSONValue(t.fieldType, value)
+
+	default:
+		return nil, fmt.Errorf("%w: cannot transcode values of type %T", ErrModel, t)
+	}
+}
+
+// fromJSONScalar converts a JSON scalar into the Go type of t.
+// Map keys are passed as json.Number so that they are parsed from their string form.
+func fromJSONScalar(t SimpleType, value any) (any, error) {
+	if t == String || t == Bytes {
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case json.Number:
+			s = string(v)
+		default:
+			return nil, fmt.Errorf("%w: expected string for %s, got %T", ErrInput, t, value)
+		}
+		if t == String {
+			return s, nil
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid base64 for %s: %w", ErrInput, t, err)
+		}
+		return b, nil
+	}
+
+	if t == Bool {
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case json.Number:
+			if b, err := strconv.ParseBool(string(v)); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: expected boolean for %s, got %v", ErrInput, t, value)
+	}
+
+	number, ok := value.(json.Number)
+	if s, isString := value.(string); isString && (t == Float32 || t == Float64) {
+		// Floats that are NaN or infinite are written as strings.
+		number, ok = json.Number(s), true
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: expected number for %s, got %T", ErrInput, t, value)
+	}
+
+	var v any
+	var err error
+	switch t {
+	case Int8:
+		var n int64
+		n, err = strconv.ParseInt(string(number), 10, 8)
+		v = int8(n)
+	case Int16:
+		var n int64
+		n, err = strconv.ParseInt(string(number), 10, 16)
+		v = int16(n)
+	case Int32:
+		var n int64
+		n, err = strconv.ParseInt(string(number), 10, 32)
+		v = int32(n)
+	case Int64:
+		v, err = strconv.ParseInt(string(number), 10, 64)
+	case Uint8:
+		var n uint64
+		n, err = strconv.ParseUint(string(number), 10, 8)
+		v = uint8(n)
+	case Uint16:
+		var n uint64
+		n, err = strconv.ParseUint(string(number), 10, 16)
+		v = uint16(n)
+	case Uint32:
+		var n uint64
+		n, err = strconv.ParseUint(string(number), 10, 32)
+		v = uint32(n)
+	case Uint64:
+		v, err = strconv.ParseUint(string(number), 10, 64)
+	case Float32:
+		var f float64
+		f, err = strconv.ParseFloat(string(number), 32)
+		v = float32(f)
+	case Float64:
+		v, err = strconv.ParseFloat(string(number), 64)
+	default:
+		return nil, fmt.Errorf("%w: unknown SimpleType: %d", ErrModel, t)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s %s", ErrInput, t, number)
+	}
+	return v, nil
+}
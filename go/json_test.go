@@ -0,0 +1,112 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	models, err := ParseSchema(testSchema)
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+	user := models["user"]
+
+	input := `{"id": 9007199254740993, "name": "bob", "tags": ["a", "b"],
+		"address": {"city": "x", "zip code": 12345}, "scores": {"7": [0.5, 1]}}`
+
+	encoded, err := FromJSON(user, []byte(input))
+	if err != nil {
+		t.Fatalf("FromJSON failed: %v", err)
+	}
+
+	output, err := ToJSON(user, encoded)
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+
+	var expected, actual any
+	if err := json.Unmarshal([]byte(input), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(output, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %s, got %s", input, output)
+	}
+}
+
+func TestFromJSONErrors(t *testing.T) {
+	models, err := ParseSchema(testSchema)
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+	user := models["user"]
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "malformed", input: `{"id": `},
+		{name: "unknown_field", input: `{"id": 1, "name": "x", "unknown": 1}`},
+		{name: "missing_required", input: `{"id": 1}`},
+		{name: "wrong_type", input: `{"id": "1", "name": "x"}`},
+		{name: "fraction_for_integer", input: `{"id": 1.5, "name": "x"}`},
+		{name: "invalid_map_key", input: `{"id": 1, "name": "x", "scores": {"x": []}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromJSON(user, []byte(tt.input)); !errors.Is(err, ErrInput) {
+				t.Errorf("Expected ErrInput, got %v", err)
+			}
+		})
+	}
+}
+
+func TestJSONSpecialValues(t *testing.T) {
+	model := newModelWithOptions(
+		&ModelOptions{Name: "special"},
+		Field(0, "flags", Map(Bool, String)),
+		Field(1, "weights", Map(Float64, Float32)),
+		Field(2, "ratio", Float64),
+		Field(3, "samples", List(Float32)),
+	)
+
+	tests := []struct {
+		name  string
+		value map[string]any
+	}{
+		{name: "bool_keys", value: map[string]any{"flags": map[bool]any{true: "on", false: "off"}}},
+		{name: "float_keys", value: map[string]any{"weights": map[float64]any{0.5: float32(1), -2: float32(3.25), 1e300: float32(0)}}},
+		{name: "nan", value: map[string]any{"ratio": math.NaN()}},
+		{name: "infinite", value: map[string]any{"ratio": math.Inf(1), "samples": []any{float32(math.Inf(-1)), float32(1.5)}}},
+		{name: "infinite_key", value: map[string]any{"weights": map[float64]any{math.Inf(-1): float32(math.NaN())}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := model.Encode(tt.value)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			output, err := ToJSON(model, encoded)
+			if err != nil {
+				t.Fatalf("ToJSON failed: %v", err)
+			}
+			// Encoding is deterministic, so the bytes compare equal even with NaN.
+			roundTrip, err := FromJSON(model, output)
+			if err != nil {
+				t.Fatalf("FromJSON of %s failed: %v", output, err)
+			}
+			if !bytes.Equal(roundTrip, encoded) {
+				t.Errorf("Round trip through %s changed the buffer", output)
+			}
+		})
+	}
+}
@@ -10,6 +10,14 @@ import (
 )
 
 func (t SimpleType) Encode(buf *bytes.Buffer, reflectValue reflect.Value) error {
+	if handled, err := encodeMarshaler(buf, t, reflectValue); handled {
+		return err
+	}
+	return t.encodeValue(buf, reflectValue)
+}
+
+// encodeValue encodes a value by its Go type without calling its marshaling methods.
+func (t SimpleType) encodeValue(buf *bytes.Buffer, reflectValue reflect.Value) error {
 	if !reflectValue.CanInterface() {
 		return fmt.Errorf("%w: value cannot be converted to a interface interface", ErrInput)
 	}
@@ -380,6 +388,9 @@ func (t SimpleType) Encode(buf *bytes.Buffer, reflectValue reflect.Value) error
 }
 
 func (t SimpleType) Decode(buf *bytes.Buffer, val reflect.Value) error {
+	if handled, err := decodeUnmarshaler(buf, t, val); handled {
+		return err
+	}
 	switch t {
 	case Int8:
 		if !val.CanSet() {
@@ -594,12 +605,7 @@ func (t SimpleType) Decode(buf *bytes.Buffer, val reflect.Value) error {
 			return fmt.Errorf("%w: failed to decode string length: %w", ErrBuffer, err)
 		}
 		if length > uint32(buf.Len()) {
-			return fmt.Errorf("string length %d exceeds buffer size %d", length, buf.Len())
-		}
-
-		if length == 0 {
-			val.SetString("")
-			return nil
+			return fmt.Errorf("%w: string length %d exceeds buffer size %d", ErrBuffer, length, buf.Len())
 		}
 
 		data := buf.Next(int(length))
@@ -627,7 +633,7 @@ func (t SimpleType) Decode(buf *bytes.Buffer, val reflect.Value) error {
 			return fmt.Errorf("%w: failed to decode bytes length: %w", ErrBuffer, err)
 		}
 		if length > uint32(buf.Len()) {
-			return fmt.Errorf("bytes length %d exceeds buffer size %d", length, buf.Len())
+			return fmt.Errorf("%w: bytes length %d exceeds buffer size %d", ErrBuffer, length, buf.Len())
 		}
 
 		data := make([]byte, length)
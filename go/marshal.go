@@ -0,0 +1,190 @@
+package butil
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"reflect"
+)
+
+// Marshaler is implemented by types that choose their own representation as a value of a field.
+// MarshalBufti receives the type of the field and returns a value that the type can encode,
+// for example an int64 for an Int64 field or a map[string]any for a Reference field.
+//
+// The returned value is encoded by its Go type: its own marshaling methods are not called, so a
+// type cannot hand its value on to another marshaler. Values nested in it, such as the elements
+// of a returned slice, are encoded as usual.
+type Marshaler interface {
+	MarshalBufti(t BuftiType) (any, error)
+}
+
+// Unmarshaler is implemented by types that restore themselves from the representation chosen by
+// their Marshaler. UnmarshalBufti receives the type of the field and the value decoded by it, as
+// it would be decoded into an interface destination, for example a map[string]any for a Reference field.
+type Unmarshaler interface {
+	UnmarshalBufti(t BuftiType, value any) error
+}
+
+var (
+	marshalerType         = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType       = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// valueEncoder is implemented by the built-in types, which encode values without calling their
+// marshaling methods with encodeValue.
+type valueEncoder interface {
+	encodeValue(buf *bytes.Buffer, val reflect.Value) error
+}
+
+// mayMarshal reports whether values of a type can have methods, which predeclared and unnamed types cannot.
+func mayMarshal(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.PkgPath() != ""
+}
+
+// encodeMarshaler encodes a value with its marshaling methods, if it has any that apply to the type.
+// Marshaler applies to all types, encoding.BinaryMarshaler to Bytes and encoding.TextMarshaler
+// to Bytes and String. It reports whether the value was handled.
+func encodeMarshaler(buf *bytes.Buffer, t BuftiType, val reflect.Value) (bool, error) {
+	if val.Kind() == reflect.Interface {
+		val = val.Elem()
+	}
+	if !val.IsValid() || !mayMarshal(val.Type()) || (val.Kind() == reflect.Pointer && val.IsNil()) {
+		return false, nil
+	}
+
+	if m, ok := marshaler(val, marshalerType); ok {
+		value, err := m.(Marshaler).MarshalBufti(t)
+		if err != nil {
+			return true, fmt.Errorf("%w: failed to marshal %s as %s: %w", ErrInput, val.Type(), t, err)
+		}
+		if value == nil {
+			return true, fmt.Errorf("%w: %s marshaled to nil as %s", ErrInput, val.Type(), t)
+		}
+		if e, ok := t.(valueEncoder); ok {
+			return true, e.encodeValue(buf, reflect.ValueOf(value))
+		}
+		return true, t.Encode(buf, reflect.ValueOf(value))
+	}
+
+	var data []byte
+	var err error
+	switch t {
+	case Bytes:
+		if m, ok := marshaler(val, binaryMarshalerType); ok {
+			data, err = m.(encoding.BinaryMarshaler).MarshalBinary()
+		} else if m, ok := marshaler(val, textMarshalerType); ok {
+			data, err = m.(encoding.TextMarshaler).MarshalText()
+		} else {
+			return false, nil
+		}
+	case String:
+		m, ok := marshaler(val, textMarshalerType)
+		if !ok {
+			return false, nil
+		}
+		data, err = m.(encoding.TextMarshaler).MarshalText()
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("%w: failed to marshal %s as %s: %w", ErrInput, val.Type(), t, err)
+	}
+
+	if t == String {
+		return true, String.Encode(buf, reflect.ValueOf(string(data)))
+	}
+	return true, Bytes.Encode(buf, reflect.ValueOf(data))
+}
+
+// marshaler returns the value, or a pointer to it if it is addressable, as an implementation of a marshaling interface.
+func marshaler(val reflect.Value, iface reflect.Type) (any, bool) {
+	if val.Type().Implements(iface) && val.CanInterface() {
+		return val.Interface(), true
+	}
+	if val.Kind() != reflect.Pointer && val.CanAddr() && reflect.PointerTo(val.Type()).Implements(iface) && val.Addr().CanInterface() {
+		return val.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+// decodeUnmarshaler decodes a value into a destination with unmarshaling methods, if it has any that
+// apply to the type, mirroring encodeMarshaler. It reports whether the value was handled.
+func decodeUnmarshaler(buf *bytes.Buffer, t BuftiType, val reflect.Value) (bool, error) {
+	if val.Kind() == reflect.Interface || !mayMarshal(val.Type()) {
+		return false, nil
+	}
+
+	if u, ok := unmarshaler(val, unmarshalerType); ok {
+		var value any
+		if err := t.Decode(buf, reflect.ValueOf(&value).Elem()); err != nil {
+			return true, err
+		}
+		if err := u.(Unmarshaler).UnmarshalBufti(t, value); err != nil {
+			return true, fmt.Errorf("%w: failed to unmarshal %s as %s: %w", ErrBuffer, val.Type(), t, err)
+		}
+		return true, nil
+	}
+
+	var u any
+	var ok, binary bool
+	switch t {
+	case Bytes:
+		if u, binary = unmarshaler(val, binaryUnmarshalerType); binary {
+			ok = true
+		} else {
+			u, ok = unmarshaler(val, textUnmarshalerType)
+		}
+	case String:
+		u, ok = unmarshaler(val, textUnmarshalerType)
+	}
+	if !ok {
+		return false, nil
+	}
+
+	var data []byte
+	if t == String {
+		var s string
+		if err := String.Decode(buf, reflect.ValueOf(&s).Elem()); err != nil {
+			return true, err
+		}
+		data = []byte(s)
+	} else if err := Bytes.Decode(buf, reflect.ValueOf(&data).Elem()); err != nil {
+		return true, err
+	}
+
+	var err error
+	if binary {
+		err = u.(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+	} else {
+		err = u.(encoding.TextUnmarshaler).UnmarshalText(data)
+	}
+	if err != nil {
+		return true, fmt.Errorf("%w: failed to unmarshal %s as %s: %w", ErrBuffer, val.Type(), t, err)
+	}
+	return true, nil
+}
+
+// unmarshaler returns a pointer to the destination as an implementation of an unmarshaling interface.
+// Nil pointer destinations are allocated first.
+func unmarshaler(val reflect.Value, iface reflect.Type) (any, bool) {
+	if val.Kind() == reflect.Pointer && val.Type().Implements(iface) {
+		if val.IsNil() {
+			if !val.CanSet() {
+				return nil, false
+			}
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		return val.Interface(), val.CanInterface()
+	}
+	if val.Kind() != reflect.Pointer && val.CanAddr() && reflect.PointerTo(val.Type()).Implements(iface) && val.Addr().CanInterface() {
+		return val.Addr().Interface(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,171 @@
+package butil
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// Money marshals to cents for integer fields and to an amount and currency for references.
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+var moneyModel = newModelWithOptions(
+	&ModelOptions{Name: "money"},
+	Field(0, "cents", Int64),
+	Field(1, "currency", String),
+)
+
+func (m Money) MarshalBufti(t BuftiType) (any, error) {
+	switch t {
+	case Int64:
+		return m.Cents, nil
+	case String:
+		return fmt.Sprintf("%d %s", m.Cents, m.Currency), nil
+	}
+	return map[string]any{"cents": m.Cents, "currency": m.Currency}, nil
+}
+
+func (m *Money) UnmarshalBufti(t BuftiType, value any) error {
+	switch v := value.(type) {
+	case int64:
+		*m = Money{Cents: v}
+	case string:
+		_, err := fmt.Sscanf(v, "%d %s", &m.Cents, &m.Currency)
+		return err
+	case map[string]any:
+		m.Cents, _ = v["cents"].(int64)
+		m.Currency, _ = v["currency"].(string)
+	default:
+		return fmt.Errorf("unexpected %T", value)
+	}
+	return nil
+}
+
+type Payment struct {
+	Amount  Money     `butil:"amount"`
+	Fee     Money     `butil:"fee"`
+	Total   *Money    `butil:"total"`
+	Address net.IP    `butil:"address"`
+	Peer    net.IP    `butil:"peer"`
+	Time    time.Time `butil:"time"`
+	Balance *big.Int  `butil:"balance"`
+}
+
+var paymentModel = newModelWithOptions(
+	&ModelOptions{Name: "payment"},
+	Field(0, "amount", Reference(moneyModel)),
+	Field(1, "fee", Int64),
+	Field(2, "total", String),
+	Field(3, "address", String),
+	Field(4, "peer", Bytes),
+	Field(5, "time", Bytes),
+	Field(6, "balance", String),
+)
+
+func TestMarshalers(t *testing.T) {
+	balance, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	original := Payment{
+		Amount:  Money{Cents: 1250, Currency: "EUR"},
+		Fee:     Money{Cents: 30},
+		Total:   &Money{Cents: 1280, Currency: "EUR"},
+		Address: net.ParseIP("192.0.2.1"),
+		Peer:    net.ParseIP("2001:db8::1"),
+		Time:    time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		Balance: balance,
+	}
+
+	encoded, err := paymentModel.Encode(original)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	var decoded Payment
+	if err := paymentModel.Decode(encoded, &decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	if decoded.Amount != original.Amount || decoded.Fee != original.Fee {
+		t.Errorf("Expected amount %+v and fee %+v, got %+v and %+v", original.Amount, original.Fee, decoded.Amount, decoded.Fee)
+	}
+	if decoded.Total == nil || *decoded.Total != *original.Total {
+		t.Errorf("Expected total %+v, got %+v", original.Total, decoded.Total)
+	}
+	if !decoded.Address.Equal(original.Address) || !decoded.Peer.Equal(original.Peer) {
+		t.Errorf("Expected addresses %s and %s, got %s and %s", original.Address, original.Peer, decoded.Address, decoded.Peer)
+	}
+	if !decoded.Time.Equal(original.Time) {
+		t.Errorf("Expected time %s, got %s", original.Time, decoded.Time)
+	}
+	if decoded.Balance == nil || decoded.Balance.Cmp(original.Balance) != 0 {
+		t.Errorf("Expected balance %s, got %s", original.Balance, decoded.Balance)
+	}
+
+	// Decoding into a map shows the representation that each type chose.
+	fields := make(map[string]any)
+	if err := paymentModel.Decode(encoded, &fields); err != nil {
+		t.Fatalf("Decode into map failed: %v", err)
+	}
+	expected := map[string]any{
+		"fee":     int64(30),
+		"total":   "1280 EUR",
+		"address": "192.0.2.1",
+		"peer":    []byte("2001:db8::1"),
+		"balance": "123456789012345678901234567890",
+	}
+	for label, value := range expected {
+		if fmt.Sprint(fields[label]) != fmt.Sprint(value) {
+			t.Errorf("Expected %s to be %v, got %v", label, value, fields[label])
+		}
+	}
+	if amount, _ := fields["amount"].(map[string]any); amount["currency"] != "EUR" {
+		t.Errorf("Expected amount to be a reference, got %v", fields["amount"])
+	}
+}
+
+// selfMarshaler returns itself from MarshalBufti, and ping and pong return each other.
+// Marshaling them again would never terminate.
+type selfMarshaler struct{}
+
+func (s selfMarshaler) MarshalBufti(BuftiType) (any, error) {
+	return s, nil
+}
+
+type ping struct{}
+
+func (ping) MarshalBufti(BuftiType) (any, error) {
+	return pong{}, nil
+}
+
+type pong struct{}
+
+func (pong) MarshalBufti(BuftiType) (any, error) {
+	return ping{}, nil
+}
+
+func TestMarshalerErrors(t *testing.T) {
+	// The result of MarshalBufti is not marshaled again, but encoded by its Go type.
+	for _, value := range []any{selfMarshaler{}, ping{}} {
+		if _, err := paymentModel.Encode(map[string]any{"fee": value}); !errors.Is(err, ErrInput) {
+			t.Errorf("Expected ErrInput for %T as an integer, got %v", value, err)
+		}
+		// An empty struct is a reference without fields.
+		if _, err := paymentModel.Encode(map[string]any{"amount": value}); err != nil {
+			t.Errorf("Expected %T to encode as an empty reference, got %v", value, err)
+		}
+	}
+
+	encoded, err := paymentModel.Encode(map[string]any{"address": "not an address"})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	var decoded Payment
+	if err := paymentModel.Decode(encoded, &decoded); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for text that does not unmarshal, got %v", err)
+	}
+}
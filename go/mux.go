@@ -0,0 +1,209 @@
+package butil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Envelope is a message tagged with the ID of its type, so that messages of many models can share one channel.
+// It is written as the type ID as a little-endian uint16, followed by the encoded message.
+type Envelope struct {
+	TypeID  uint16
+	Message []byte
+}
+
+// ParseEnvelope splits data into its type ID and message, without decoding the message.
+// The message shares its memory with data.
+//
+// Returns ErrBuffer if data is too short to hold a type ID.
+func ParseEnvelope(data []byte) (Envelope, error) {
+	if len(data) < 2 {
+		return Envelope{}, fmt.Errorf("%w: failed to read envelope type ID", ErrBuffer)
+	}
+	return Envelope{TypeID: binary.LittleEndian.Uint16(data), Message: data[2:]}, nil
+}
+
+// Bytes returns the encoded envelope.
+func (e Envelope) Bytes() []byte {
+	return append(binary.LittleEndian.AppendUint16(make([]byte, 0, 2+len(e.Message)), e.TypeID), e.Message...)
+}
+
+// Mux maps type IDs to models and Go types. It encodes values into envelopes, and decodes
+// envelopes into values of the registered type or dispatches them to registered handlers.
+// A Mux is safe for concurrent use.
+type Mux struct {
+	entries   map[uint16]*muxEntry
+	types     map[reflect.Type]uint16
+	ambiguous map[reflect.Type]bool
+	mu        sync.RWMutex
+}
+
+type muxEntry struct {
+	model   *Model
+	t       reflect.Type
+	handler func(any) error
+}
+
+// NewMux creates an empty Mux.
+func NewMux() *Mux {
+	return &Mux{
+		entries:   make(map[uint16]*muxEntry),
+		types:     make(map[reflect.Type]uint16),
+		ambiguous: make(map[reflect.Type]bool),
+	}
+}
+
+// Register adds a type ID for messages encoded with the model. Messages with the ID are decoded
+// into values of the type of prototype, which can be a struct or a pointer to one.
+// If prototype is nil, they are decoded into a map[string]any.
+//
+// Returns ErrModel if the type ID is already registered.
+func (x *Mux) Register(id uint16, model *Model, prototype any) error {
+	t := reflect.TypeOf(map[string]any(nil))
+	if prototype != nil {
+		t = indirectType(reflect.TypeOf(prototype))
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, exists := x.entries[id]; exists {
+		return fmt.Errorf("%w: type ID %d is already registered", ErrModel, id)
+	}
+	x.entries[id] = &muxEntry{model: model, t: t}
+
+	if _, exists := x.types[t]; exists {
+		x.ambiguous[t] = true
+	} else {
+		x.types[t] = id
+	}
+	return nil
+}
+
+// Handle sets the function that Dispatch calls with the decoded values of a type ID.
+// The handler receives values of the registered type, as returned by Decode.
+//
+// Returns ErrInput if the type ID is not registered.
+func (x *Mux) Handle(id uint16, handler func(value any) error) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	entry, exists := x.entries[id]
+	if !exists {
+		return fmt.Errorf("%w: type ID %d is not registered", ErrInput, id)
+	}
+	entry.handler = handler
+	return nil
+}
+
+// Encode encodes value into an envelope, with the type ID its Go type is registered with.
+//
+// Returns ErrInput if the type of value is not registered, or registered with several type IDs.
+// Returns the errors of Model.Encode if value cannot be encoded.
+func (x *Mux) Encode(value any) ([]byte, error) {
+	if value == nil {
+		return nil, fmt.Errorf("%w: cannot encode nil", ErrInput)
+	}
+	t := indirectType(reflect.TypeOf(value))
+
+	x.mu.RLock()
+	id, exists := x.types[t]
+	ambiguous := x.ambiguous[t]
+	x.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: type %s is not registered", ErrInput, t)
+	}
+	if ambiguous {
+		return nil, fmt.Errorf("%w: type %s is registered with several type IDs, use EncodeID", ErrInput, t)
+	}
+	return x.EncodeID(id, value)
+}
+
+// EncodeID encodes value into an envelope with the given type ID.
+//
+// Returns ErrInput if the type ID is not registered.
+// Returns the errors of Model.Encode if value cannot be encoded.
+func (x *Mux) EncodeID(id uint16, value any) ([]byte, error) {
+	entry, err := x.entry(id, ErrInput)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := entry.model.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return Envelope{TypeID: id, Message: encoded}.Bytes(), nil
+}
+
+// Decode decodes an envelope into a value of the type registered for its type ID.
+// Struct types are returned as values, not pointers.
+//
+// Returns ErrBuffer if the type ID is not registered, or the message is corrupted.
+// Returns the errors of Model.Decode if the message cannot be decoded.
+func (x *Mux) Decode(data []byte) (any, error) {
+	envelope, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := x.entry(envelope.TypeID, ErrBuffer)
+	if err != nil {
+		return nil, err
+	}
+	return entry.decode(envelope.Message)
+}
+
+// Dispatch decodes an envelope and calls the handler of its type ID with the value,
+// returning the error of the handler.
+//
+// Returns ErrInput if no handler is set for the type ID.
+// Returns the errors of Decode if the envelope cannot be decoded.
+func (x *Mux) Dispatch(data []byte) error {
+	envelope, err := ParseEnvelope(data)
+	if err != nil {
+		return err
+	}
+	entry, err := x.entry(envelope.TypeID, ErrBuffer)
+	if err != nil {
+		return err
+	}
+
+	x.mu.RLock()
+	handler := entry.handler
+	x.mu.RUnlock()
+	if handler == nil {
+		return fmt.Errorf("%w: no handler for type ID %d", ErrInput, envelope.TypeID)
+	}
+
+	value, err := entry.decode(envelope.Message)
+	if err != nil {
+		return err
+	}
+	return handler(value)
+}
+
+// entry returns the entry of a type ID, or an error wrapping kind if it is not registered.
+func (x *Mux) entry(id uint16, kind error) (*muxEntry, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	entry, exists := x.entries[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: type ID %d is not registered", kind, id)
+	}
+	return entry, nil
+}
+
+func (e *muxEntry) decode(message []byte) (any, error) {
+	v := reflect.New(e.t)
+	if e.t.Kind() == reflect.Map {
+		v.Elem().Set(reflect.MakeMap(e.t))
+	}
+	if err := e.model.Decode(message, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
@@ -0,0 +1,123 @@
+package butil
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	mux := NewMux()
+	if err := mux.Register(1, simpleModel, SimpleStruct{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Register(2, complexModel, &ComplexStruct{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Register(3, simpleModel, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	simple := SimpleStruct{ID: 1, Name: "simple"}
+	complex := compressibleStruct()
+
+	data, err := mux.Encode(&simple)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	envelope, err := ParseEnvelope(data)
+	if err != nil || envelope.TypeID != 1 {
+		t.Fatalf("Expected type ID 1, got %d, %v", envelope.TypeID, err)
+	}
+	decoded, err := mux.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, simple) {
+		t.Errorf("Expected %+v, got %+v", simple, decoded)
+	}
+
+	data, err = mux.Encode(complex)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err = mux.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, complex) {
+		t.Error("Decoded value differs from the original")
+	}
+
+	data, err = mux.EncodeID(3, simple)
+	if err != nil {
+		t.Fatalf("EncodeID failed: %v", err)
+	}
+	decoded, err = mux.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if fields, ok := decoded.(map[string]any); !ok || fields["name"] != "simple" {
+		t.Errorf("Expected a map with the fields, got %#v", decoded)
+	}
+
+	var handled []any
+	if err := mux.Handle(1, func(value any) error {
+		handled = append(handled, value)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = mux.Encode(simple)
+	if err := mux.Dispatch(data); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if len(handled) != 1 || !reflect.DeepEqual(handled[0], simple) {
+		t.Errorf("Expected the handler to receive %+v, got %v", simple, handled)
+	}
+}
+
+func TestMuxErrors(t *testing.T) {
+	mux := NewMux()
+	if err := mux.Register(1, simpleModel, SimpleStruct{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Register(1, complexModel, ComplexStruct{}); !errors.Is(err, ErrModel) {
+		t.Errorf("Expected ErrModel for a duplicate type ID, got %v", err)
+	}
+	if err := mux.Handle(9, func(any) error { return nil }); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unregistered handler, got %v", err)
+	}
+
+	if _, err := mux.Encode(ComplexStruct{}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unregistered type, got %v", err)
+	}
+	if err := mux.Register(2, simpleModel, SimpleStruct{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mux.Encode(SimpleStruct{}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an ambiguous type, got %v", err)
+	}
+
+	data, err := mux.EncodeID(1, SimpleStruct{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Dispatch(data); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput without a handler, got %v", err)
+	}
+
+	unknown := Envelope{TypeID: 7, Message: data[2:]}.Bytes()
+	if _, err := mux.Decode(unknown); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for an unknown type ID, got %v", err)
+	}
+	if _, err := mux.Decode(data[:1]); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a truncated envelope, got %v", err)
+	}
+
+	failure := errors.New("handler failed")
+	mux.Handle(1, func(any) error { return failure })
+	if err := mux.Dispatch(data); err != failure {
+		t.Errorf("Expected the handler error, got %v", err)
+	}
+}
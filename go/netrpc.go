@@ -0,0 +1,183 @@
+package butil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/rpc"
+	"sync"
+)
+
+// Method holds the models that the request and response bodies of an RPC method are encoded with.
+type Method struct {
+	Request  *Model
+	Response *Model
+}
+
+// Methods maps RPC method names, such as "Service.Method" for net/rpc, to their models.
+type Methods map[string]Method
+
+var rpcRequestModel = newModelWithOptions(
+	&ModelOptions{Name: "rpc request", RequiredByDefault: true},
+	Field(0, "ServiceMethod", String),
+	Field(1, "Seq", Uint64),
+)
+
+var rpcResponseModel = newModelWithOptions(
+	&ModelOptions{Name: "rpc response", RequiredByDefault: true},
+	Field(0, "ServiceMethod", String),
+	Field(1, "Seq", Uint64),
+	Field(2, "Error", String),
+)
+
+// rpcCodec holds what the client and server codecs share. Every header and body is written
+// as a frame with a uint32 length, and bodies of failed calls as empty frames.
+type rpcCodec struct {
+	conn    io.ReadWriteCloser
+	r       *bufio.Reader
+	w       *bufio.Writer
+	methods Methods
+	method  string // method of the header that was read last
+	mu      sync.Mutex
+}
+
+func newRPCCodec(conn io.ReadWriteCloser, methods Methods) rpcCodec {
+	return rpcCodec{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), methods: methods}
+}
+
+func (c *rpcCodec) lookup(method string) (Method, error) {
+	m, exists := c.methods[method]
+	if !exists {
+		return Method{}, fmt.Errorf("%w: method %s is not registered", ErrInput, method)
+	}
+	return m, nil
+}
+
+// write writes an encoded header and body and flushes the connection.
+func (c *rpcCodec) write(header, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := WriteFrame(c.w, header, nil); err != nil {
+		return err
+	}
+	if err := WriteFrame(c.w, body, nil); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *rpcCodec) readHeader(headerModel *Model, header any) error {
+	frame, err := ReadFrame(c.r, nil)
+	if err != nil {
+		return err
+	}
+	return headerModel.Decode(frame, header)
+}
+
+// readBody reads a body frame, and decodes it unless body is nil.
+func (c *rpcCodec) readBody(model func(Method) *Model, body any) error {
+	frame, err := ReadFrame(c.r, nil)
+	if err != nil || body == nil {
+		return err
+	}
+	m, err := c.lookup(c.method)
+	if err != nil {
+		return err
+	}
+	return model(m).Decode(frame, body)
+}
+
+func (c *rpcCodec) Close() error {
+	return c.conn.Close()
+}
+
+type serverCodec struct {
+	rpcCodec
+}
+
+// NewServerCodec returns a net/rpc server codec that reads requests from conn and writes responses to it.
+// Headers are encoded with built-in models, and bodies with the models of their method.
+// Calls of methods that are not in methods fail without being decoded.
+func NewServerCodec(conn io.ReadWriteCloser, methods Methods) rpc.ServerCodec {
+	return &serverCodec{newRPCCodec(conn, methods)}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.readHeader(rpcRequestModel, r); err != nil {
+		return err
+	}
+	c.method = r.ServiceMethod
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body any) error {
+	return c.readBody(func(m Method) *Model { return m.Request }, body)
+}
+
+// WriteResponse writes a response. If the body cannot be encoded, the call fails with the error
+// instead, so that the client does not wait for it forever. If the response cannot be written,
+// the connection is closed.
+func (c *serverCodec) WriteResponse(r *rpc.Response, body any) error {
+	var encodedBody []byte
+	var bodyErr error
+	if r.Error == "" {
+		var m Method
+		if m, bodyErr = c.lookup(r.ServiceMethod); bodyErr == nil {
+			encodedBody, bodyErr = m.Response.Encode(body)
+		}
+		if bodyErr != nil {
+			failed := *r
+			failed.Error = bodyErr.Error()
+			r, encodedBody = &failed, nil
+		}
+	}
+
+	header, err := rpcResponseModel.Encode(r)
+	if err == nil {
+		err = c.write(header, encodedBody)
+	}
+	if err != nil {
+		c.Close()
+		return err
+	}
+	return bodyErr
+}
+
+type clientCodec struct {
+	rpcCodec
+}
+
+// NewClientCodec returns a net/rpc client codec that writes requests to conn and reads responses from it.
+// Use it with rpc.NewClientWithCodec. Calls of methods that are not in methods fail with ErrInput.
+func NewClientCodec(conn io.ReadWriteCloser, methods Methods) rpc.ClientCodec {
+	return &clientCodec{newRPCCodec(conn, methods)}
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body any) error {
+	m, err := c.lookup(r.ServiceMethod)
+	if err != nil {
+		return err
+	}
+	header, err := rpcRequestModel.Encode(r)
+	if err != nil {
+		return err
+	}
+	encodedBody, err := m.Request.Encode(body)
+	if err != nil {
+		return err
+	}
+	return c.write(header, encodedBody)
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	if err := c.readHeader(rpcResponseModel, r); err != nil {
+		return err
+	}
+	c.method = r.ServiceMethod
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(body any) error {
+	return c.readBody(func(m Method) *Model { return m.Response }, body)
+}
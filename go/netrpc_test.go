@@ -0,0 +1,131 @@
+package butil
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ArithArgs struct {
+	A int64 `butil:"a"`
+	B int64 `butil:"b"`
+}
+
+type ArithReply struct {
+	Result int64 `butil:"result"`
+}
+
+type Arith struct{}
+
+func (Arith) Add(args *ArithArgs, reply *ArithReply) error {
+	reply.Result = args.A + args.B
+	return nil
+}
+
+func (Arith) Divide(args *ArithArgs, reply *ArithReply) error {
+	if args.B == 0 {
+		return errors.New("division by zero")
+	}
+	reply.Result = args.A / args.B
+	return nil
+}
+
+var (
+	arithArgsModel  = newModel(Field(0, "a", Int64), Field(1, "b", Int64))
+	arithReplyModel = newModel(Field(0, "result", Int64))
+	arithMethods    = Methods{
+		"Arith.Add":    {Request: arithArgsModel, Response: arithReplyModel},
+		"Arith.Divide": {Request: arithArgsModel, Response: arithReplyModel},
+	}
+)
+
+func TestNetRPC(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go server.ServeCodec(NewServerCodec(serverConn, arithMethods))
+
+	clientMethods := Methods{"Arith.Missing": {Request: arithArgsModel, Response: arithReplyModel}}
+	for name, method := range arithMethods {
+		clientMethods[name] = method
+	}
+	client := rpc.NewClientWithCodec(NewClientCodec(clientConn, clientMethods))
+	defer client.Close()
+
+	var reply ArithReply
+	if err := client.Call("Arith.Add", &ArithArgs{A: 2, B: 3}, &reply); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if reply.Result != 5 {
+		t.Errorf("Expected 5, got %d", reply.Result)
+	}
+
+	// Calls can be in flight concurrently.
+	calls := make([]*rpc.Call, 10)
+	for i := range calls {
+		calls[i] = client.Go("Arith.Add", &ArithArgs{A: int64(i), B: 1}, &ArithReply{}, nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			t.Errorf("Call %d failed: %v", i, call.Error)
+		} else if result := call.Reply.(*ArithReply).Result; result != int64(i)+1 {
+			t.Errorf("Call %d: expected %d, got %d", i, i+1, result)
+		}
+	}
+
+	err := client.Call("Arith.Divide", &ArithArgs{A: 1}, &reply)
+	if _, ok := err.(rpc.ServerError); !ok || err.Error() != "division by zero" {
+		t.Errorf("Expected the server error, got %v", err)
+	}
+	if err := client.Call("Arith.Missing", &ArithArgs{}, &reply); err == nil {
+		t.Error("Expected an error for a method the server does not have")
+	}
+	if err := client.Call("Arith.Unknown", &ArithArgs{}, &reply); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unregistered method, got %v", err)
+	}
+
+	// The connection is still usable after failed calls.
+	if err := client.Call("Arith.Add", &ArithArgs{A: 4, B: 4}, &reply); err != nil || reply.Result != 8 {
+		t.Errorf("Expected 8, got %d, %v", reply.Result, err)
+	}
+}
+
+func TestNetRPCResponseError(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server's response model of Arith.Add cannot encode the integer result.
+	serverMethods := Methods{
+		"Arith.Add":    {Request: arithArgsModel, Response: newModel(Field(0, "result", String))},
+		"Arith.Divide": arithMethods["Arith.Divide"],
+	}
+	serverConn, clientConn := net.Pipe()
+	go server.ServeCodec(NewServerCodec(serverConn, serverMethods))
+	client := rpc.NewClientWithCodec(NewClientCodec(clientConn, arithMethods))
+	defer client.Close()
+
+	call := client.Go("Arith.Add", &ArithArgs{A: 2, B: 3}, &ArithReply{}, nil)
+	select {
+	case <-call.Done:
+		if _, ok := call.Error.(rpc.ServerError); !ok || !strings.Contains(call.Error.Error(), ErrInput.Error()) {
+			t.Errorf("Expected a server error for a reply that does not encode, got %v", call.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Call did not return for a reply that does not encode")
+	}
+
+	// The connection is still usable after the failed call.
+	var reply ArithReply
+	if err := client.Call("Arith.Divide", &ArithArgs{A: 8, B: 2}, &reply); err != nil || reply.Result != 4 {
+		t.Errorf("Expected 4, got %d, %v", reply.Result, err)
+	}
+}
@@ -0,0 +1,169 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// EncodeOptions configures how EncodeWithOptions wraps the encoded payload.
+type EncodeOptions struct {
+	// Sign signs the canonical encoding, before it is compressed or sealed.
+	Sign *SignOptions
+	// Compression selects the format the payload is compressed with.
+	Compression Compression
+	// CompressionThreshold is the payload size in bytes below which the payload is not compressed.
+	CompressionThreshold int
+	// Seal encrypts and authenticates the payload with AES-GCM, after it was compressed.
+	Seal *SealOptions
+	// Checksum appends a CRC-32C checksum of the whole message, which Decode verifies before parsing.
+	Checksum bool
+}
+
+// DecodeOptions configures how DecodeWithOptions unwraps the encoded payload.
+type DecodeOptions struct {
+	// MaxDecompressedSize is the largest size in bytes a compressed payload may expand to.
+	// If it is zero, DefaultMaxDecompressedSize is used.
+	MaxDecompressedSize int
+	// Keys provides the keys to open sealed messages with.
+	Keys KeyProvider
+	// Keyring enables signature verification. If it is set, messages that are not signed by
+	// a key of the keyring are rejected. Otherwise signatures are not checked.
+	Keyring Keyring
+}
+
+// EncodeWithOptions serializes the given data like Encode, and then wraps the payload as configured by options.
+// The header records how the payload was wrapped, so Decode can unwrap it without any options,
+// except for the keys of sealed messages. If options is nil, the result is the same as for Encode.
+//
+// Returns ErrInput if the data is nil or of an unsupported type, or the options are invalid.
+// Returns ErrModel if required fields are missing or schema validation fails.
+func (m *Model) EncodeWithOptions(data any, options *EncodeOptions) ([]byte, error) {
+	encoded, err := m.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+	if options == nil {
+		return encoded, nil
+	}
+	return wrap(encoded, options)
+}
+
+// DecodeWithOptions deserializes binary data like Decode, using options to unwrap the payload.
+// If options is nil, the defaults are used.
+//
+// Returns ErrInput if dest is not a pointer or is nil.
+// Returns ErrVersion if the data was encoded with an incompatible protocol version.
+// Returns ErrChecksum if the data has a checksum that does not match.
+// Returns ErrAuthentication if the data is sealed and cannot be authenticated with the keys of options.
+// Returns ErrSignature if options has a keyring and the data is not signed by one of its keys.
+// Returns ErrBuffer if the data is corrupted, cannot be parsed or exceeds the limits of options.
+// Returns ErrModel if the data references fields not defined in the schema.
+func (m *Model) DecodeWithOptions(data []byte, dest any, options *DecodeOptions) error {
+	if dest == nil {
+		return fmt.Errorf("%w: cannot decode into nil", ErrInput)
+	}
+
+	data, err := unwrap(data, options)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(dest)
+	t := reflect.TypeOf(dest)
+
+	if t.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("%w: dest has to be a pointer, instead: %s", ErrInput, t.Kind())
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+
+	if _, err := buf.Write(data); err != nil {
+		return err
+	}
+
+	if err := readVersion(buf); err != nil {
+		return err
+	}
+
+	return m.decode(buf, t, v)
+}
+
+// wrap applies options to an encoded message.
+func wrap(encoded []byte, options *EncodeOptions) ([]byte, error) {
+	wrapped := encoded
+	var err error
+	if options.Sign != nil {
+		if wrapped, err = sign(wrapped, options.Sign); err != nil {
+			return nil, err
+		}
+	}
+	if wrapped, err = compress(wrapped, options.Compression, options.CompressionThreshold); err != nil {
+		return nil, err
+	}
+	if options.Seal != nil {
+		if wrapped, err = seal(wrapped, options.Seal); err != nil {
+			return nil, err
+		}
+	}
+	if options.Checksum {
+		wrapped = appendChecksum(wrapped)
+	}
+	return wrapped, nil
+}
+
+// unwrap turns a message with header flags into a plain message without them,
+// checking the checksum, opening the seal, decompressing the payload and checking the signature
+// as the flags require.
+// Plain messages are returned as they are.
+func unwrap(data []byte, options *DecodeOptions) ([]byte, error) {
+	if options == nil {
+		options = &DecodeOptions{}
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("%w: failed to read protocol version", ErrBuffer)
+	}
+
+	header := binary.LittleEndian.Uint32(data)
+	if err := checkVersion(header &^ (flagChecksum | flagSealed | flagFlate | flagGzip | flagSigned)); err != nil {
+		return nil, err
+	}
+	if options.Keyring != nil && header&flagSigned == 0 {
+		return nil, fmt.Errorf("%w: message is not signed", ErrSignature)
+	}
+	if header == ProtocolVersion {
+		return data, nil
+	}
+
+	payload := data[4:]
+	var err error
+	if header&flagChecksum != 0 {
+		if payload, err = verifyChecksum(data); err != nil {
+			return nil, err
+		}
+	}
+	if header&flagSealed != 0 {
+		if payload, err = open(header, payload, options.Keys); err != nil {
+			return nil, err
+		}
+	}
+	if header&(flagFlate|flagGzip) != 0 {
+		if payload, err = decompress(header, payload, options.MaxDecompressedSize); err != nil {
+			return nil, err
+		}
+	}
+	if header&flagSigned != 0 {
+		if payload, err = verifySignature(header, payload, options.Keyring); err != nil {
+			return nil, err
+		}
+	}
+
+	plain := make([]byte, 4, 4+len(payload))
+	binary.LittleEndian.PutUint32(plain, header&versionMask)
+	return append(plain, payload...), nil
+}
@@ -0,0 +1,216 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// slot is the location of the value that a path selects within an encoded buffer.
+type slot struct {
+	t          BuftiType // type of the selected value
+	countAt    int       // offset of the count of the model, list or map that holds the value
+	start      int       // offset of the entry, including its field index or map key
+	valueStart int       // offset of the value
+	end        int       // offset after the entry, equal to start if it was not found
+	found      bool
+	entry      []byte // field index or encoded map key that precedes an inserted value
+	required   bool   // whether the selected field is required
+}
+
+// Set writes value at path in an encoded buffer and returns the updated buffer.
+// The path is written as for Get. A field or map entry that is not set is inserted,
+// in the position Encode would have written it, and the field count or map length is updated.
+// List elements and the values leading up to the last segment of the path have to exist.
+//
+// If the encoded value has the same size as the old one, as for all fixed width types,
+// it is overwritten in place and data itself is returned. Otherwise the rest of the buffer
+// is moved and a new slice is returned.
+// The whole buffer is checked against the model before anything is changed.
+// Compressed buffers are rejected with ErrVersion.
+//
+// Returns ErrInput if the path is malformed, does not match the model schema, or value does not match its type.
+// Returns ErrNotFound if a list element or a value that the path goes through is not set.
+// Returns ErrVersion if the data was encoded with an incompatible protocol version.
+// Returns ErrBuffer if the data is corrupted or cannot be parsed.
+func Set(model *Model, data []byte, path string, value any) ([]byte, error) {
+	if value == nil {
+		return nil, fmt.Errorf("%w: cannot set %s to nil", ErrInput, path)
+	}
+
+	s, err := locate(model, data, path)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := new(bytes.Buffer)
+	if err := s.t.Encode(encoded, reflect.ValueOf(value)); err != nil {
+		return nil, fmt.Errorf("%w: cannot set %s: %w", ErrInput, path, err)
+	}
+
+	if s.found {
+		if encoded.Len() == s.end-s.valueStart {
+			copy(data[s.valueStart:], encoded.Bytes())
+			return data, nil
+		}
+		return splice(data, s.valueStart, s.end, encoded.Bytes()), nil
+	}
+
+	entry := append(s.entry, encoded.Bytes()...)
+	result := splice(data, s.start, s.end, entry)
+	addCount(result, s.countAt, 1)
+	return result, nil
+}
+
+// Remove deletes the field, list element or map entry at path from an encoded buffer
+// and returns the updated buffer, with the field count or length of its container updated.
+// The path is written as for Get. The whole buffer is checked against the model before anything is changed.
+// Compressed buffers are rejected with ErrVersion.
+//
+// Returns ErrInput if the path is malformed, does not match the model schema, or selects a required field.
+// Returns ErrNotFound if the value at path or a value that the path goes through is not set.
+// Returns ErrVersion if the data was encoded with an incompatible protocol version.
+// Returns ErrBuffer if the data is corrupted or cannot be parsed.
+func Remove(model *Model, data []byte, path string) ([]byte, error) {
+	s, err := locate(model, data, path)
+	if err != nil {
+		return nil, err
+	}
+	if s.required {
+		return nil, fmt.Errorf("%w: cannot remove required field %s", ErrInput, path)
+	}
+	if !s.found {
+		return nil, fmt.Errorf("%w: %s is not set", ErrNotFound, path)
+	}
+
+	result := splice(data, s.start, s.end, nil)
+	addCount(result, s.countAt, -1)
+	return result, nil
+}
+
+// splice returns a new buffer with data[start:end] replaced by insert.
+func splice(data []byte, start, end int, insert []byte) []byte {
+	result := make([]byte, 0, len(data)-(end-start)+len(insert))
+	result = append(result, data[:start]...)
+	result = append(result, insert...)
+	return append(result, data[end:]...)
+}
+
+func addCount(data []byte, offset int, delta int) {
+	count := binary.LittleEndian.Uint32(data[offset:])
+	binary.LittleEndian.PutUint32(data[offset:], uint32(int(count)+delta))
+}
+
+// locate finds the slot that path selects in data, after checking the whole buffer against the model.
+func locate(model *Model, data []byte, path string) (slot, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return slot{}, err
+	}
+
+	buf := bytes.NewBuffer(data)
+	if err := readVersion(buf); err != nil {
+		return slot{}, err
+	}
+	if err := skipValue(buf, Reference(model)); err != nil {
+		return slot{}, err
+	}
+
+	buf = bytes.NewBuffer(data[4:])
+	t, err := seek(buf, Reference(model), segments[:len(segments)-1])
+	if err != nil {
+		return slot{}, err
+	}
+
+	// The buffer has been checked against the model above, so the reads below cannot fail.
+	offset := func() int { return len(data) - buf.Len() }
+	last := segments[len(segments)-1]
+
+	switch container := t.(type) {
+	case ReferenceType:
+		m := container.model
+		if last.bracket {
+			return slot{}, fmt.Errorf("%w: cannot select %s in model %s", ErrInput, last, m.name)
+		}
+		index, exists := m.labels[last.text]
+		if !exists {
+			return slot{}, fmt.Errorf("%w: field %s not found in model %s", ErrInput, last.text, m.name)
+		}
+		field := m.schema[index]
+		s := slot{
+			t:        field.fieldType,
+			countAt:  offset(),
+			start:    -1,
+			entry:    []byte{index},
+			required: field.isRequired == nil || *field.isRequired,
+		}
+
+		fieldCount, _ := readUint32(buf, "field count")
+		for range fieldCount {
+			start := offset()
+			current, _ := buf.ReadByte()
+			if current == index {
+				s.start, s.valueStart, s.found = start, offset(), true
+				_ = skipValue(buf, field.fieldType)
+				s.end = offset()
+				return s, nil
+			}
+			if current > index && s.start < 0 {
+				s.start = start
+			}
+			_ = skipValue(buf, m.schema[current].fieldType)
+		}
+		if s.start < 0 {
+			s.start = offset()
+		}
+		s.end = s.start
+		return s, nil
+
+	case ListType:
+		countAt := offset()
+		elementType, err := seekElement(buf, container, last)
+		if err != nil {
+			return slot{}, err
+		}
+		s := slot{t: elementType, countAt: countAt, start: offset(), valueStart: offset(), found: true}
+		_ = skipValue(buf, elementType)
+		s.end = offset()
+		return s, nil
+
+	case MapType:
+		key, err := parsePathKey(container.keyType, last)
+		if err != nil {
+			return slot{}, err
+		}
+		entry := new(bytes.Buffer)
+		if err := container.keyType.Encode(entry, reflect.ValueOf(key)); err != nil {
+			return slot{}, err
+		}
+		s := slot{t: container.valueType, countAt: offset(), start: -1, entry: entry.Bytes()}
+
+		length, _ := readUint32(buf, "map length")
+		for range length {
+			start := offset()
+			var current any
+			_ = container.keyType.Decode(buf, reflect.ValueOf(&current).Elem())
+			valueStart := offset()
+			_ = skipValue(buf, container.valueType)
+			if reflect.DeepEqual(current, key) {
+				s.start, s.valueStart, s.end, s.found = start, valueStart, offset(), true
+				return s, nil
+			}
+			if s.start < 0 && compareKeys(reflect.ValueOf(key), reflect.ValueOf(current)) < 0 {
+				s.start = start
+			}
+		}
+		if s.start < 0 {
+			s.start = offset()
+		}
+		s.end = s.start
+		return s, nil
+
+	default:
+		return slot{}, fmt.Errorf("%w: cannot select %s in %s", ErrInput, last, t)
+	}
+}
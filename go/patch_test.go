@@ -0,0 +1,160 @@
+package butil
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSetInPlace(t *testing.T) {
+	data, err := simpleModel.Encode(SimpleStruct{ID: 1, Name: "abc", Age: 2, Rate: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patched, err := Set(simpleModel, data, "age", int32(3))
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if &patched[0] != &data[0] {
+		t.Error("Expected fixed width field to be overwritten in place")
+	}
+
+	var decoded SimpleStruct
+	if err := simpleModel.Decode(patched, &decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	expected := SimpleStruct{ID: 1, Name: "abc", Age: 3, Rate: 0.5}
+	if decoded != expected {
+		t.Errorf("Expected %+v, got %+v", expected, decoded)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	message := projectionModels(t)["message"]
+
+	base := map[string]any{
+		"id": int64(1),
+		"user": map[string]any{
+			"id":     int64(2),
+			"name":   "ada",
+			"tags":   []any{"a", "b", "c"},
+			"scores": map[uint8]any{1: []any{0.5}, 5: []any{}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		patch    func(data []byte) ([]byte, error)
+		expected func(user map[string]any, message map[string]any)
+	}{
+		{
+			name:     "update_string",
+			patch:    func(data []byte) ([]byte, error) { return Set(message, data, "user.name", "grace") },
+			expected: func(user, _ map[string]any) { user["name"] = "grace" },
+		},
+		{
+			name:     "insert_field",
+			patch:    func(data []byte) ([]byte, error) { return Set(message, data, "payload", []byte{1, 2}) },
+			expected: func(_, message map[string]any) { message["payload"] = []byte{1, 2} },
+		},
+		{
+			name: "insert_nested_field",
+			patch: func(data []byte) ([]byte, error) {
+				return Set(message, data, "user.address", map[string]any{"city": "Berlin"})
+			},
+			expected: func(user, _ map[string]any) { user["address"] = map[string]any{"city": "Berlin"} },
+		},
+		{
+			name:     "update_element",
+			patch:    func(data []byte) ([]byte, error) { return Set(message, data, "user.tags[1]", "bee") },
+			expected: func(user, _ map[string]any) { user["tags"] = []any{"a", "bee", "c"} },
+		},
+		{
+			name:     "update_entry",
+			patch:    func(data []byte) ([]byte, error) { return Set(message, data, "user.scores[1]", []float64{1, 2}) },
+			expected: func(user, _ map[string]any) { user["scores"].(map[uint8]any)[1] = []any{1.0, 2.0} },
+		},
+		{
+			name:     "insert_entry",
+			patch:    func(data []byte) ([]byte, error) { return Set(message, data, "user.scores[3]", []float64{}) },
+			expected: func(user, _ map[string]any) { user["scores"].(map[uint8]any)[3] = []any{} },
+		},
+		{
+			name:     "remove_field",
+			patch:    func(data []byte) ([]byte, error) { return Remove(message, data, "user.tags") },
+			expected: func(user, _ map[string]any) { delete(user, "tags") },
+		},
+		{
+			name:     "remove_element",
+			patch:    func(data []byte) ([]byte, error) { return Remove(message, data, "user.tags[0]") },
+			expected: func(user, _ map[string]any) { user["tags"] = []any{"b", "c"} },
+		},
+		{
+			name:     "remove_entry",
+			patch:    func(data []byte) ([]byte, error) { return Remove(message, data, "user.scores[5]") },
+			expected: func(user, _ map[string]any) { delete(user["scores"].(map[uint8]any), 5) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := message.Encode(base)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			patched, err := tt.patch(data)
+			if err != nil {
+				t.Fatalf("Patch failed: %v", err)
+			}
+
+			// Patching yields the bytes Encode produces for the changed value.
+			changed := make(map[string]any)
+			if err := message.Decode(data, &changed); err != nil {
+				t.Fatal(err)
+			}
+			tt.expected(changed["user"].(map[string]any), changed)
+			expected, err := message.Encode(changed)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if !bytes.Equal(patched, expected) {
+				t.Errorf("Patched bytes differ\nexpected %x\ngot      %x", expected, patched)
+			}
+		})
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	message := projectionModels(t)["message"]
+	data, err := message.Encode(map[string]any{
+		"id":   int64(1),
+		"user": map[string]any{"id": int64(2), "name": "x", "tags": []any{"a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		patch    func() ([]byte, error)
+		expected error
+	}{
+		{name: "wrong_type", patch: func() ([]byte, error) { return Set(message, data, "id", "one") }, expected: ErrInput},
+		{name: "nil", patch: func() ([]byte, error) { return Set(message, data, "id", nil) }, expected: ErrInput},
+		{name: "unknown_field", patch: func() ([]byte, error) { return Set(message, data, "missing", 1) }, expected: ErrInput},
+		{name: "remove_required", patch: func() ([]byte, error) { return Remove(message, data, "user.name") }, expected: ErrInput},
+		{name: "missing_parent", patch: func() ([]byte, error) { return Set(message, data, "user.address.city", "y") }, expected: ErrNotFound},
+		{name: "element_out_of_range", patch: func() ([]byte, error) { return Set(message, data, "user.tags[1]", "b") }, expected: ErrNotFound},
+		{name: "remove_unset", patch: func() ([]byte, error) { return Remove(message, data, "payload") }, expected: ErrNotFound},
+		{name: "corrupted", patch: func() ([]byte, error) { return Set(message, data[:len(data)-1], "id", int64(2)) }, expected: ErrBuffer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.patch(); !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
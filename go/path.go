@@ -0,0 +1,231 @@
+package butil
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is one step of a path: a field label, or the contents of brackets
+// that select a list element or a map entry.
+type pathSegment struct {
+	text    string
+	bracket bool
+}
+
+func (s pathSegment) String() string {
+	if s.bracket {
+		return "[" + s.text + "]"
+	}
+	return "." + s.text
+}
+
+// parsePath splits a path such as `items[2].price` or `scores["a"]` into segments.
+// A path starts with a field label, followed by `.label` to select a field of a nested model
+// and `[...]` to select a list element by index or a map entry by key. Keys are written as in
+// the text format, so string keys are quoted.
+func parsePath(path string) ([]pathSegment, error) {
+	var segments []pathSegment
+	i := 0
+	for i < len(path) {
+		if path[i] == '[' {
+			end := i + 1
+			for quoted := false; end < len(path) && (quoted || path[end] != ']'); end++ {
+				switch {
+				case path[end] == '\\' && quoted:
+					end++
+				case path[end] == '"':
+					quoted = !quoted
+				}
+			}
+			if end >= len(path) {
+				return nil, fmt.Errorf("%w: unterminated [ in path %q", ErrInput, path)
+			}
+			segments = append(segments, pathSegment{text: strings.TrimSpace(path[i+1 : end]), bracket: true})
+			i = end + 1
+			continue
+		}
+
+		if len(segments) > 0 {
+			if path[i] != '.' {
+				return nil, fmt.Errorf("%w: expected . or [ at offset %d in path %q", ErrInput, i, path)
+			}
+			i++
+		}
+		end := strings.IndexAny(path[i:], ".[]")
+		if end < 0 {
+			end = len(path) - i
+		} else if path[i+end] == ']' {
+			return nil, fmt.Errorf("%w: unexpected ] at offset %d in path %q", ErrInput, i+end, path)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("%w: empty label at offset %d in path %q", ErrInput, i, path)
+		}
+		segments = append(segments, pathSegment{text: path[i : i+end]})
+		i += end
+	}
+
+	if len(segments) == 0 || segments[0].bracket {
+		return nil, fmt.Errorf("%w: path %q has to start with a field label", ErrInput, path)
+	}
+	return segments, nil
+}
+
+// Get reads the value at path from an encoded buffer, without decoding anything else.
+// Values that come before it are skipped using the lengths in the buffer.
+// The path selects fields with labels separated by dots and list elements or map entries
+// with brackets, for example `items[2].price`, `address.city` or `scores["math"]`.
+// It returns the value, decoded as by Decode into an interface, and the offset in data at which it starts.
+// Compressed buffers are rejected with ErrVersion, since the offset could not refer to data.
+//
+// Returns ErrInput if the path is malformed or does not match the model schema.
+// Returns ErrNotFound if the buffer does not contain the field, element or entry.
+// Returns ErrVersion if the data was encoded with an incompatible protocol version.
+// Returns ErrBuffer if the data is corrupted or cannot be parsed.
+func Get(model *Model, data []byte, path string) (any, int, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	buf := bytes.NewBuffer(data)
+	if err := readVersion(buf); err != nil {
+		return nil, 0, err
+	}
+
+	t, err := seek(buf, Reference(model), segments)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := len(data) - buf.Len()
+	var value any
+	if err := t.Decode(buf, reflect.ValueOf(&value).Elem()); err != nil {
+		return nil, 0, err
+	}
+	return value, offset, nil
+}
+
+// seek advances buf to the start of the value that segments select within a value of type t,
+// and returns the type of that value.
+func seek(buf *bytes.Buffer, t BuftiType, segments []pathSegment) (BuftiType, error) {
+	for _, segment := range segments {
+		var err error
+		switch current := t.(type) {
+		case ReferenceType:
+			t, err = seekField(buf, current.model, segment)
+		case ListType:
+			t, err = seekElement(buf, current, segment)
+		case MapType:
+			t, err = seekEntry(buf, current, segment)
+		default:
+			return nil, fmt.Errorf("%w: cannot select %s in %s", ErrInput, segment, t)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func seekField(buf *bytes.Buffer, m *Model, segment pathSegment) (BuftiType, error) {
+	if segment.bracket {
+		return nil, fmt.Errorf("%w: cannot select %s in model %s", ErrInput, segment, m.name)
+	}
+	if _, exists := m.labels[segment.text]; !exists {
+		return nil, fmt.Errorf("%w: field %s not found in model %s", ErrInput, segment.text, m.name)
+	}
+
+	fieldCount, err := readUint32(buf, "field count")
+	if err != nil {
+		return nil, err
+	}
+	for range fieldCount {
+		index, err := buf.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read field index: %w", ErrBuffer, err)
+		}
+		schemaField, exists := m.schema[index]
+		if !exists {
+			return nil, fmt.Errorf("%w: index %d does not exist on model %s", ErrBuffer, index, m.name)
+		}
+		if schemaField.label == segment.text {
+			return schemaField.fieldType, nil
+		}
+		if err := skipValue(buf, schemaField.fieldType); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: field %s is not set", ErrNotFound, segment.text)
+}
+
+func seekElement(buf *bytes.Buffer, t ListType, segment pathSegment) (BuftiType, error) {
+	if !segment.bracket {
+		return nil, fmt.Errorf("%w: cannot select %s in %s", ErrInput, segment, t)
+	}
+	index, err := strconv.ParseUint(segment.text, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid list index %s", ErrInput, segment)
+	}
+
+	length, err := readUint32(buf, "list length")
+	if err != nil {
+		return nil, err
+	}
+	if index >= uint64(length) {
+		return nil, fmt.Errorf("%w: list index %d out of range for length %d", ErrNotFound, index, length)
+	}
+	for range index {
+		if err := skipValue(buf, t.elementType); err != nil {
+			return nil, err
+		}
+	}
+	return t.elementType, nil
+}
+
+func seekEntry(buf *bytes.Buffer, t MapType, segment pathSegment) (BuftiType, error) {
+	if !segment.bracket {
+		return nil, fmt.Errorf("%w: cannot select %s in %s", ErrInput, segment, t)
+	}
+	key, err := parsePathKey(t.keyType, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := readUint32(buf, "map length")
+	if err != nil {
+		return nil, err
+	}
+	for range length {
+		var current any
+		if err := t.keyType.Decode(buf, reflect.ValueOf(&current).Elem()); err != nil {
+			return nil, err
+		}
+		if reflect.DeepEqual(current, key) {
+			return t.valueType, nil
+		}
+		if err := skipValue(buf, t.valueType); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: map key %s is not set", ErrNotFound, segment.text)
+}
+
+// parsePathKey parses the key of a map entry in a path, written as in the text format.
+func parsePathKey(keyType SimpleType, segment pathSegment) (any, error) {
+	p := &textParser{src: segment.text}
+	tok, err := p.next()
+	if err != nil {
+		return nil, fmt.Errorf("invalid map key %s: %w", segment, err)
+	}
+	key, err := p.parseScalar(keyType, tok)
+	if err != nil {
+		return nil, fmt.Errorf("invalid map key %s: %w", segment, err)
+	}
+	if tok, _ := p.next(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("%w: invalid map key %s", ErrInput, segment)
+	}
+	return key, nil
+}
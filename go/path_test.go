@@ -0,0 +1,149 @@
+package butil
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []pathSegment
+	}{
+		{path: "id", expected: []pathSegment{{text: "id"}}},
+		{path: "zip code", expected: []pathSegment{{text: "zip code"}}},
+		{path: "items[2].price", expected: []pathSegment{{text: "items"}, {text: "2", bracket: true}, {text: "price"}}},
+		{path: "a[0][1]", expected: []pathSegment{{text: "a"}, {text: "0", bracket: true}, {text: "1", bracket: true}}},
+		{path: `m["x]y"].z`, expected: []pathSegment{{text: "m"}, {text: `"x]y"`, bracket: true}, {text: "z"}}},
+		{path: `m["a\"]"]`, expected: []pathSegment{{text: "m"}, {text: `"a\"]"`, bracket: true}}},
+	}
+
+	for _, tt := range tests {
+		segments, err := parsePath(tt.path)
+		if err != nil {
+			t.Errorf("%q: parsePath failed: %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(segments, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.path, tt.expected, segments)
+		}
+	}
+
+	for _, path := range []string{"", "[0]", "a.", "a..b", "a[0", "a]b", ".a"} {
+		if _, err := parsePath(path); !errors.Is(err, ErrInput) {
+			t.Errorf("%q: expected ErrInput, got %v", path, err)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	models := projectionModels(t)
+	message := models["message"]
+
+	user := map[string]any{
+		"id":      int64(7),
+		"name":    "ada",
+		"tags":    []any{"a", "b", "c"},
+		"address": map[string]any{"city": "Berlin", "zip code": uint32(10115)},
+		"scores":  map[uint8]any{1: []any{0.5}, 200: []any{1.5, 2.5}},
+	}
+	data, err := message.Encode(map[string]any{
+		"id":      int64(1),
+		"payload": []byte{1, 2, 3},
+		"user":    user,
+		"history": []any{map[string]any{"id": int64(8), "name": "bob"}, user},
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		expected any
+	}{
+		{path: "id", expected: int64(1)},
+		{path: "payload", expected: []byte{1, 2, 3}},
+		{path: "user.name", expected: "ada"},
+		{path: "user.tags[2]", expected: "c"},
+		{path: "user.address.zip code", expected: uint32(10115)},
+		{path: "user.address", expected: user["address"]},
+		{path: "user.scores[200][1]", expected: 2.5},
+		{path: "history[0].name", expected: "bob"},
+		{path: "history[1].scores[1]", expected: []any{0.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			value, offset, err := Get(message, data, tt.path)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if !reflect.DeepEqual(value, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, value)
+			}
+			if offset <= 4 || offset >= len(data) {
+				t.Errorf("Offset %d is out of range", offset)
+			}
+		})
+	}
+}
+
+func TestGetOffset(t *testing.T) {
+	data, err := simpleModel.Encode(SimpleStruct{ID: 1, Name: "abc", Age: 2, Rate: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// header (4), field count (4), index 0 (1), id (8), index 1 (1), name (4+3), index 2 (1)
+	_, offset, err := Get(simpleModel, data, "age")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if offset != 26 {
+		t.Errorf("Expected offset 26, got %d", offset)
+	}
+	if data[offset] != 2 {
+		t.Errorf("Expected age at offset %d, got %x", offset, data[offset:])
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	message := projectionModels(t)["message"]
+	data, err := message.Encode(map[string]any{
+		"id":   int64(1),
+		"user": map[string]any{"id": int64(2), "name": "x", "tags": []any{"a"}, "scores": map[uint8]any{1: []any{}}},
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		expected error
+	}{
+		{path: "missing", expected: ErrInput},
+		{path: "user.missing", expected: ErrInput},
+		{path: "id.value", expected: ErrInput},
+		{path: "id[0]", expected: ErrInput},
+		{path: "user[0]", expected: ErrInput},
+		{path: "user.tags.x", expected: ErrInput},
+		{path: "user.tags[x]", expected: ErrInput},
+		{path: "user.scores[300]", expected: ErrInput},
+		{path: `user.scores["1"]`, expected: ErrInput},
+		{path: "payload", expected: ErrNotFound},
+		{path: "user.address.city", expected: ErrNotFound},
+		{path: "user.tags[1]", expected: ErrNotFound},
+		{path: "user.scores[2]", expected: ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		if _, _, err := Get(message, data, tt.path); !errors.Is(err, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.path, tt.expected, err)
+		}
+	}
+
+	if _, _, err := Get(message, data[:12], "user.name"); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a truncated buffer, got %v", err)
+	}
+}
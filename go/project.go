@@ -0,0 +1,187 @@
+package butil
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// projection is the set of fields requested from a model, keyed by label.
+// A nil projection for a field selects the whole field.
+type projection map[string]projection
+
+// DecodeFields deserializes only the fields with the given labels into dest, and skips over all others
+// without decoding them. Nested fields of referenced models are selected with dotted paths, such as
+// "user.address.city". Selecting a field also selects everything below it.
+// The destination must be a pointer to a struct or map[string]any, as for Decode.
+// Fields that are not selected are left unchanged in dest. Checksums and compressed payloads are handled as by Decode.
+//
+// Returns ErrInput if dest is not a pointer, a label does not exist on the model, a path continues past
+// a field that is not a reference, or a selected field is missing on the destination struct.
+// Returns ErrVersion if the data was encoded with an incompatible protocol version.
+// Returns ErrChecksum if the data has a checksum that does not match.
+// Returns ErrBuffer if the data is corrupted, cannot be parsed, or a selected required field is missing.
+func (m *Model) DecodeFields(data []byte, dest any, labels ...string) error {
+	if dest == nil {
+		return fmt.Errorf("%w: cannot decode into nil", ErrInput)
+	}
+
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("%w: dest has to be a pointer, instead: %s", ErrInput, v.Kind())
+	}
+
+	p, err := m.projection(labels)
+	if err != nil {
+		return err
+	}
+
+	data, err = unwrap(data, nil)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(data)
+	if err := readVersion(buf); err != nil {
+		return err
+	}
+	return m.decodeProjected(buf, v, p)
+}
+
+// projection builds the projection for dotted label paths and checks them against the schema.
+func (m *Model) projection(paths []string) (projection, error) {
+	root := make(projection)
+	for _, path := range paths {
+		model := m
+		p := root
+		segments := strings.Split(path, ".")
+		for i, label := range segments {
+			index, exists := model.labels[label]
+			if !exists {
+				return nil, fmt.Errorf("%w: field %s not found in model %s", ErrInput, label, model.name)
+			}
+
+			if i == len(segments)-1 {
+				p[label] = nil
+				break
+			}
+
+			ref, ok := model.schema[index].fieldType.(ReferenceType)
+			if !ok {
+				return nil, fmt.Errorf("%w: cannot select %s in %s, field %s is not a reference", ErrInput, path, model.name, label)
+			}
+
+			sub, selected := p[label]
+			if selected && sub == nil {
+				// The whole field is already selected.
+				break
+			}
+			if !selected {
+				sub = make(projection)
+				p[label] = sub
+			}
+			model = ref.model
+			p = sub
+		}
+	}
+	return root, nil
+}
+
+func (m *Model) decodeProjected(buf *bytes.Buffer, v reflect.Value, p projection) error {
+	fieldCount, err := readUint32(buf, "field count")
+	if err != nil {
+		return err
+	}
+
+	v = indirectValue(v)
+
+	var fieldMap map[string]int
+	switch v.Kind() {
+	case reflect.Struct:
+		fieldMap = m.structFields(v.Type())
+		for label := range p {
+			if _, exists := fieldMap[label]; !exists {
+				return fmt.Errorf("%w: field %s not found on %s", ErrInput, label, v.Type())
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.Interface {
+			return fmt.Errorf("%w: destination has to be a map[string]any, instead: %s", ErrInput, v.Type())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	default:
+		return fmt.Errorf("%w: invalid destination type %s", ErrInput, v.Kind())
+	}
+
+	seen := make(map[string]bool, len(p))
+	for range fieldCount {
+		index, err := buf.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: failed to read field index: %w", ErrBuffer, err)
+		}
+
+		schemaField, exists := m.schema[index]
+		if !exists {
+			return fmt.Errorf("%w: index %d does not exist on model %s", ErrBuffer, index, m.name)
+		}
+
+		sub, selected := p[schemaField.label]
+		if !selected {
+			if err := skipValue(buf, schemaField.fieldType); err != nil {
+				return err
+			}
+			continue
+		}
+		seen[schemaField.label] = true
+
+		if v.Kind() == reflect.Struct {
+			value := v.Field(fieldMap[schemaField.label])
+			if sub == nil {
+				err = schemaField.fieldType.Decode(buf, value)
+			} else {
+				err = decodeProjectedReference(buf, schemaField, value, sub)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var mapValue any
+		if sub == nil {
+			err = schemaField.fieldType.Decode(buf, reflect.ValueOf(&mapValue).Elem())
+		} else {
+			err = decodeProjectedReference(buf, schemaField, reflect.ValueOf(&mapValue).Elem(), sub)
+		}
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(reflect.ValueOf(schemaField.label), reflect.ValueOf(mapValue))
+	}
+
+	for label := range p {
+		field := m.schema[m.labels[label]]
+		if field.isRequired != nil && *field.isRequired && !seen[label] {
+			return fmt.Errorf("%w: required field %s is missing for model %s", ErrBuffer, label, m.name)
+		}
+	}
+	return nil
+}
+
+// decodeProjectedReference decodes the selected fields of a nested model into v.
+// Interface destinations receive a map[string]any, as with Decode.
+func decodeProjectedReference(buf *bytes.Buffer, field ModelField, v reflect.Value, p projection) error {
+	model := field.fieldType.(ReferenceType).model
+	if v.Kind() == reflect.Interface {
+		fields := make(map[string]any)
+		if err := model.decodeProjected(buf, reflect.ValueOf(&fields), p); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(fields))
+		return nil
+	}
+	return model.decodeProjected(buf, v, p)
+}
@@ -0,0 +1,207 @@
+package butil
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const projectionSchema = testSchema + `
+model message {
+  0 id: int64
+  optional 1 payload: bytes
+  optional 2 user: user
+  optional 3 history: list<user>
+}
+`
+
+func projectionModels(t *testing.T) map[string]*Model {
+	t.Helper()
+	models, err := ParseSchema(projectionSchema)
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+	return models
+}
+
+func TestDecodeFields(t *testing.T) {
+	message := projectionModels(t)["message"]
+
+	user := map[string]any{
+		"id":      int64(7),
+		"name":    "ada",
+		"tags":    []any{"a", "b"},
+		"address": map[string]any{"city": "Berlin", "zip code": uint32(10115)},
+		"scores":  map[uint8]any{1: []any{0.5}},
+	}
+	encoded, err := message.Encode(map[string]any{
+		"id":      int64(1),
+		"payload": make([]byte, 4096),
+		"user":    user,
+		"history": []any{user, user},
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		labels   []string
+		expected map[string]any
+	}{
+		{
+			name:     "top_level",
+			labels:   []string{"id"},
+			expected: map[string]any{"id": int64(1)},
+		},
+		{
+			name:   "nested",
+			labels: []string{"id", "user.address.city"},
+			expected: map[string]any{
+				"id":   int64(1),
+				"user": map[string]any{"address": map[string]any{"city": "Berlin"}},
+			},
+		},
+		{
+			name:   "siblings",
+			labels: []string{"user.name", "user.address.zip code"},
+			expected: map[string]any{
+				"user": map[string]any{"name": "ada", "address": map[string]any{"zip code": uint32(10115)}},
+			},
+		},
+		{
+			name:     "whole_reference",
+			labels:   []string{"user.address", "user.address.city"},
+			expected: map[string]any{"user": map[string]any{"address": user["address"]}},
+		},
+		{
+			name:     "none",
+			labels:   nil,
+			expected: map[string]any{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded := make(map[string]any)
+			if err := message.DecodeFields(encoded, &decoded, tt.labels...); err != nil {
+				t.Fatalf("DecodeFields failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeFieldsStruct(t *testing.T) {
+	original := NestedStruct{
+		ID:       1,
+		Simple:   SimpleStruct{ID: 2, Name: "simple", Age: 3, Rate: 0.5},
+		Children: []SimpleStruct{{ID: 4}},
+	}
+	encoded, err := nestedModel.Encode(original)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	decoded := NestedStruct{Children: []SimpleStruct{{ID: 9}}}
+	if err := nestedModel.DecodeFields(encoded, &decoded, "simple.name", "simple.age"); err != nil {
+		t.Fatalf("DecodeFields failed: %v", err)
+	}
+
+	expected := NestedStruct{
+		Simple:   SimpleStruct{Name: "simple", Age: 3},
+		Children: []SimpleStruct{{ID: 9}},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, decoded)
+	}
+}
+
+func TestDecodeFieldsErrors(t *testing.T) {
+	models := projectionModels(t)
+	message := models["message"]
+
+	encoded, err := message.Encode(map[string]any{"id": int64(1)})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	partialUser, err := message.Encode(map[string]any{"id": int64(1), "user": map[string]any{"id": int64(2), "name": "x", "address": map[string]any{"city": "y"}}})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	type idOnly struct {
+		ID int64 `butil:"id"`
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		dest     any
+		labels   []string
+		expected error
+	}{
+		{name: "unknown_label", data: encoded, dest: &map[string]any{}, labels: []string{"missing"}, expected: ErrInput},
+		{name: "unknown_nested_label", data: encoded, dest: &map[string]any{}, labels: []string{"user.missing"}, expected: ErrInput},
+		{name: "not_a_reference", data: encoded, dest: &map[string]any{}, labels: []string{"id.value"}, expected: ErrInput},
+		{name: "path_through_list", data: encoded, dest: &map[string]any{}, labels: []string{"history.name"}, expected: ErrInput},
+		{name: "missing_struct_field", data: encoded, dest: &idOnly{}, labels: []string{"payload"}, expected: ErrInput},
+		{name: "not_a_pointer", data: encoded, dest: map[string]any{}, labels: []string{"id"}, expected: ErrInput},
+		{name: "optional_missing", data: partialUser, dest: &map[string]any{}, labels: []string{"payload", "id"}, expected: nil},
+		{name: "truncated", data: partialUser[:len(partialUser)-2], dest: &map[string]any{}, labels: []string{"id"}, expected: ErrBuffer},
+		{name: "version", data: []byte{2, 0, 0, 0, 0, 0, 0, 0}, dest: &map[string]any{}, labels: []string{"id"}, expected: ErrVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := message.DecodeFields(tt.data, tt.dest, tt.labels...)
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	user := models["user"]
+	withoutName, err := user.Encode(map[string]any{"id": int64(1), "name": ""})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	// Drop the name field from the buffer, leaving only the id.
+	withoutName[4] = 1
+	withoutName = withoutName[:len(withoutName)-5]
+	if err := user.DecodeFields(withoutName, &map[string]any{}, "name"); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a missing required field, got %v", err)
+	}
+}
+
+func TestDecodeFieldsSkipAllocations(t *testing.T) {
+	message := projectionModels(t)["message"]
+
+	history := make([]any, 100)
+	for i := range history {
+		history[i] = map[string]any{"id": int64(i), "name": "user", "tags": []any{"a", "b", "c"}}
+	}
+	encoded, err := message.Encode(map[string]any{
+		"id":      int64(1),
+		"payload": make([]byte, 1<<16),
+		"history": history,
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	var dest struct {
+		ID int64 `butil:"id"`
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := message.DecodeFields(encoded, &dest, "id"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// The projection and buffer are allocated once per call, skipped fields must not add to that.
+	if allocs > 8 {
+		t.Errorf("Expected skipped fields not to allocate, got %v allocations", allocs)
+	}
+}
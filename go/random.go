@@ -0,0 +1,352 @@
+package butil
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"unicode/utf8"
+)
+
+// maxGeneratorDepth bounds nesting when required references keep the generator from stopping at MaxDepth.
+const maxGeneratorDepth = 64
+
+// GeneratorOptions configures the values produced by a Generator.
+type GeneratorOptions struct {
+	// MaxLength is the maximum number of elements in generated lists, maps, strings and bytes.
+	MaxLength int
+	// MaxDepth is the nesting depth after which optional references are omitted and collections are empty.
+	MaxDepth int
+	// OptionalProbability is the probability between 0 and 1 that an optional field is set.
+	OptionalProbability float64
+}
+
+// DefaultGeneratorOptions are used by NewGenerator if no options are given.
+var DefaultGeneratorOptions = GeneratorOptions{
+	MaxLength:           8,
+	MaxDepth:            4,
+	OptionalProbability: 0.5,
+}
+
+// Generator builds random values that are valid for a model, for use in property based tests.
+// Every numeric type covers its full range, with a bias towards zero and the limits.
+// Generators with the same seed and options produce the same values.
+// A Generator is not safe for concurrent use.
+type Generator struct {
+	rnd     *rand.Rand
+	options GeneratorOptions
+}
+
+// NewGenerator creates a generator from a seed.
+// If options is nil, DefaultGeneratorOptions are used.
+func NewGenerator(seed uint64, options *GeneratorOptions) *Generator {
+	if options == nil {
+		options = &DefaultGeneratorOptions
+	}
+	return &Generator{
+		rnd:     rand.New(rand.NewPCG(seed, seed)),
+		options: *options,
+	}
+}
+
+// Map generates a random value for the model as a map[string]any, in the shape Decode produces:
+// lists are []any, maps have the key type of the schema and any values, and nested models are map[string]any.
+//
+// Returns ErrModel if the model cannot be generated, for example because it requires itself.
+func (g *Generator) Map(model *Model) (map[string]any, error) {
+	return g.fields(model, 0)
+}
+
+// Fill populates dest with a random value for the model.
+// The destination must be a pointer to a struct or map[string]any. Struct fields are mapped to
+// schema fields using either the field name or the `butil` tag, optional fields that are not
+// chosen keep their zero value.
+//
+// Returns ErrInput if dest is not a pointer or a field type does not match the schema.
+// Returns ErrModel if the model cannot be generated, for example because it requires itself.
+func (g *Generator) Fill(model *Model, dest any) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("%w: dest has to be a pointer, instead: %T", ErrInput, dest)
+	}
+	return g.fill(Reference(model), v.Elem(), 0)
+}
+
+func (g *Generator) fields(m *Model, depth int) (map[string]any, error) {
+	if depth > maxGeneratorDepth {
+		return nil, fmt.Errorf("%w: model %s is nested deeper than %d levels", ErrModel, m.name, maxGeneratorDepth)
+	}
+
+	fields := make(map[string]any, len(m.schema))
+	for _, index := range m.indices() {
+		field := m.schema[index]
+		if !g.include(field, depth) {
+			continue
+		}
+		v, err := g.value(field.fieldType, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		fields[field.label] = v
+	}
+	return fields, nil
+}
+
+// include decides whether a field is generated.
+func (g *Generator) include(field ModelField, depth int) bool {
+	if field.isRequired == nil || *field.isRequired {
+		return true
+	}
+	if depth >= g.options.MaxDepth {
+		return false
+	}
+	return g.rnd.Float64() < g.options.OptionalProbability
+}
+
+func (g *Generator) length(depth int) int {
+	if depth > g.options.MaxDepth || g.options.MaxLength <= 0 {
+		return 0
+	}
+	return g.rnd.IntN(g.options.MaxLength + 1)
+}
+
+func (g *Generator) value(t BuftiType, depth int) (any, error) {
+	switch t := t.(type) {
+	case SimpleType:
+		return g.scalar(t)
+
+	case ListType:
+		list := make([]any, g.length(depth))
+		for i := range list {
+			v, err := g.value(t.elementType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+
+	case MapType:
+		keyType, err := t.keyType.reflectType()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrModel, err)
+		}
+		result := reflect.MakeMap(reflect.MapOf(keyType, reflect.TypeOf((*any)(nil)).Elem()))
+		for range g.length(depth) {
+			key, err := g.scalar(t.keyType)
+			if err != nil {
+				return nil, err
+			}
+			v, err := g.value(t.valueType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			result.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(&v).Elem())
+		}
+		return result.Interface(), nil
+
+	case ReferenceType:
+		return g.fields(t.model, depth)
+
+	case encryptedType:
+		return g.value(t.fieldType, depth)
+
+	default:
+		return nil, fmt.Errorf("%w: cannot generate values of type %T", ErrModel, t)
+	}
+}
+
+func (g *Generator) fill(t BuftiType, v reflect.Value, depth int) error {
+	if v.Kind() == reflect.Interface {
+		value, err := g.value(t, depth)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(value))
+		return nil
+	}
+	if v.Kind() == reflect.Pointer {
+		v.Set(reflect.New(v.Type().Elem()))
+		return g.fill(t, v.Elem(), depth)
+	}
+
+	switch t := t.(type) {
+	case SimpleType:
+		value, err := g.scalar(t)
+		if err != nil {
+			return err
+		}
+		return setScalar(v, reflect.ValueOf(value))
+
+	case ListType:
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("%w: cannot fill %s with %s", ErrInput, v.Type(), t)
+		}
+		n := g.length(depth)
+		slice := reflect.MakeSlice(v.Type(), n, n)
+		for i := range n {
+			if err := g.fill(t.elementType, slice.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+
+	case MapType:
+		if v.Kind() != reflect.Map {
+			return fmt.Errorf("%w: cannot fill %s with %s", ErrInput, v.Type(), t)
+		}
+		result := reflect.MakeMap(v.Type())
+		for range g.length(depth) {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := g.fill(t.keyType, key, depth+1); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := g.fill(t.valueType, value, depth+1); err != nil {
+				return err
+			}
+			result.SetMapIndex(key, value)
+		}
+		v.Set(result)
+
+	case ReferenceType:
+		switch v.Kind() {
+		case reflect.Struct:
+			return g.fillStruct(t.model, v, depth)
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.Interface {
+				return fmt.Errorf("%w: destination has to be a map[string]any, instead: %s", ErrInput, v.Type())
+			}
+			fields, err := g.fields(t.model, depth)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(fields))
+		default:
+			return fmt.Errorf("%w: cannot fill %s with %s", ErrInput, v.Type(), t)
+		}
+
+	case encryptedType:
+		return g.fill(t.fieldType, v, depth)
+
+	default:
+		return fmt.Errorf("%w: cannot generate values of type %T", ErrModel, t)
+	}
+	return nil
+}
+
+func (g *Generator) fillStruct(m *Model, v reflect.Value, depth int) error {
+	if depth > maxGeneratorDepth {
+		return fmt.Errorf("%w: model %s is nested deeper than %d levels", ErrModel, m.name, maxGeneratorDepth)
+	}
+
+	fieldMap := m.structFields(v.Type())
+	for _, index := range m.indices() {
+		field := m.schema[index]
+
+		i, exists := fieldMap[field.label]
+		if !exists {
+			if field.isRequired == nil || *field.isRequired {
+				return fmt.Errorf("%w: required field %s is missing on %s", ErrInput, field.label, v.Type())
+			}
+			continue
+		}
+		if !g.include(field, depth) {
+			continue
+		}
+		if err := g.fill(field.fieldType, v.Field(i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setScalar assigns a generated scalar to a destination of a compatible kind,
+// accepting the same destinations as SimpleType.Decode.
+func setScalar(v, value reflect.Value) error {
+	compatible := v.Kind() == value.Kind() ||
+		(v.Kind() == reflect.Int && value.Kind() == reflect.Int64) ||
+		(v.Kind() == reflect.Uint && value.Kind() == reflect.Uint32)
+
+	if !compatible || !value.Type().ConvertibleTo(v.Type()) {
+		return fmt.Errorf("%w: cannot set %s value to %s", ErrInput, value.Type(), v.Type())
+	}
+	v.Set(value.Convert(v.Type()))
+	return nil
+}
+
+func (g *Generator) scalar(t SimpleType) (any, error) {
+	switch t {
+	case Bool:
+		return g.rnd.IntN(2) == 1, nil
+	case Uint8:
+		return uint8(g.bits(0, math.MaxUint8)), nil
+	case Uint16:
+		return uint16(g.bits(0, math.MaxUint16)), nil
+	case Uint32:
+		return uint32(g.bits(0, math.MaxUint32)), nil
+	case Uint64:
+		return g.bits(0, math.MaxUint64), nil
+	case Int8:
+		return int8(g.bits(1<<7, math.MaxUint8)), nil
+	case Int16:
+		return int16(g.bits(1<<15, math.MaxUint16)), nil
+	case Int32:
+		return int32(g.bits(1<<31, math.MaxUint32)), nil
+	case Int64:
+		return int64(g.bits(1<<63, math.MaxUint64)), nil
+	case Float32:
+		for {
+			f := math.Float32frombits(uint32(g.bits(0, math.MaxUint32)))
+			if !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0) {
+				return f, nil
+			}
+		}
+	case Float64:
+		for {
+			f := math.Float64frombits(g.bits(0, math.MaxUint64))
+			if !math.IsNaN(f) && !math.IsInf(f, 0) {
+				return f, nil
+			}
+		}
+	case String:
+		runes := make([]rune, g.length(0))
+		for i := range runes {
+			// Mostly ASCII, with multi-byte runes to exercise UTF-8 handling.
+			if g.rnd.IntN(4) == 0 {
+				r := rune(0x80 + g.rnd.IntN(utf8.MaxRune-0x80))
+				if !utf8.ValidRune(r) {
+					r = utf8.RuneError
+				}
+				runes[i] = r
+			} else {
+				runes[i] = rune(0x20 + g.rnd.IntN(0x5f))
+			}
+		}
+		return string(runes), nil
+	case Bytes:
+		b := make([]byte, g.length(0))
+		for i := range b {
+			b[i] = byte(g.rnd.Uint32())
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown SimpleType: %d", ErrModel, t)
+	}
+}
+
+// bits returns random bits masked to the width of a type, picking the edge values
+// zero, minimum and maximum more often than a uniform distribution would.
+// For signed types, minimum is the bit pattern of the minimum value.
+func (g *Generator) bits(minimum, mask uint64) uint64 {
+	switch g.rnd.IntN(8) {
+	case 0:
+		return 0
+	case 1:
+		return minimum
+	case 2:
+		return (minimum - 1) & mask
+	default:
+		return g.rnd.Uint64() & mask
+	}
+}
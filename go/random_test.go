@@ -0,0 +1,99 @@
+package butil
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGeneratorMapRoundTrip(t *testing.T) {
+	models, err := ParseSchema(testSchema)
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+
+	for _, model := range []*Model{simpleModel, complexModel, nestedModel, models["user"]} {
+		g := NewGenerator(1, nil)
+		for range 200 {
+			original, err := g.Map(model)
+			if err != nil {
+				t.Fatalf("Map failed: %v", err)
+			}
+
+			encoded, err := model.Encode(original)
+			if err != nil {
+				t.Fatalf("Encode of %v failed: %v", original, err)
+			}
+
+			decoded := make(map[string]any)
+			if err := model.Decode(encoded, &decoded); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(original, decoded) {
+				t.Fatalf("Expected %v, got %v", original, decoded)
+			}
+		}
+	}
+}
+
+func TestGeneratorFillRoundTrip(t *testing.T) {
+	tests := []struct {
+		model *Model
+		dest  func() any
+	}{
+		{model: simpleModel, dest: func() any { return &SimpleStruct{} }},
+		{model: complexModel, dest: func() any { return &ComplexStruct{} }},
+		{model: nestedModel, dest: func() any { return &NestedStruct{} }},
+	}
+
+	for _, tt := range tests {
+		g := NewGenerator(2, &GeneratorOptions{MaxLength: 3, MaxDepth: 2, OptionalProbability: 1})
+		for range 200 {
+			original := tt.dest()
+			if err := g.Fill(tt.model, original); err != nil {
+				t.Fatalf("Fill failed: %v", err)
+			}
+
+			encoded, err := tt.model.Encode(original)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+
+			decoded := tt.dest()
+			if err := tt.model.Decode(encoded, decoded); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(original, decoded) {
+				t.Fatalf("Expected %+v, got %+v", original, decoded)
+			}
+		}
+	}
+}
+
+func TestGeneratorSeed(t *testing.T) {
+	first, err := NewGenerator(42, nil).Map(complexModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewGenerator(42, nil).Map(complexModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := complexModel.Encode(first)
+	b, _ := complexModel.Encode(second)
+	if !bytes.Equal(a, b) {
+		t.Error("Expected generators with the same seed to produce the same value")
+	}
+}
+
+func TestGeneratorRecursiveModel(t *testing.T) {
+	models, err := ParseSchema(`model a { 0 self: a }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGenerator(1, nil).Map(models["a"]); !errors.Is(err, ErrModel) {
+		t.Errorf("Expected ErrModel, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package butil
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// RawMessage is an encoded Reference or List value that is kept as bytes instead of being decoded.
+// It lets a message pass through nested values it does not need to look at.
+//
+// When a struct field of type RawMessage is matched to a Reference or List field, Decode stores the
+// bytes of the value and Encode writes them back verbatim. A RawMessage holds the value without a
+// protocol version header, and can be decoded later with Model.DecodeRaw or RawMessage.Decode.
+// An empty RawMessage encodes as a reference without fields or a list without elements.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// Decode deserializes the raw value into dest according to the type it was read as,
+// for example Reference(model) or List(Reference(model)).
+// The destination must be a pointer to a value that the type can decode into.
+//
+// Returns ErrInput if dest is not a pointer.
+// Returns ErrBuffer if the raw value is corrupted or longer than a single value.
+func (r RawMessage) Decode(t BuftiType, dest any) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("%w: dest has to be a pointer, instead: %T", ErrInput, dest)
+	}
+
+	buf := bytes.NewBuffer(r)
+	if err := t.Decode(buf, v.Elem()); err != nil {
+		return err
+	}
+	if buf.Len() != 0 {
+		return fmt.Errorf("%w: %d unexpected bytes after %s", ErrBuffer, buf.Len(), t)
+	}
+	return nil
+}
+
+// DecodeRaw deserializes a RawMessage that was read from a reference to this model.
+// It accepts the same destinations as Decode.
+//
+// Returns ErrInput if dest is not a pointer.
+// Returns ErrBuffer if the raw value is corrupted.
+func (m *Model) DecodeRaw(raw RawMessage, dest any) error {
+	return raw.Decode(Reference(m), dest)
+}
+
+// encodeRaw writes a RawMessage after checking that it holds exactly one value of type t.
+func encodeRaw(buf *bytes.Buffer, t BuftiType, raw RawMessage) error {
+	if len(raw) == 0 {
+		_, err := buf.Write([]byte{0, 0, 0, 0})
+		return err
+	}
+
+	check := bytes.NewBuffer(raw)
+	if err := skipValue(check, t); err != nil {
+		return fmt.Errorf("%w: raw message is not a valid %s: %w", ErrInput, t, err)
+	}
+	if check.Len() != 0 {
+		return fmt.Errorf("%w: raw message has %d unexpected bytes after %s", ErrInput, check.Len(), t)
+	}
+	_, err := buf.Write(raw)
+	return err
+}
+
+// decodeRaw reads the bytes of one value of type t into a RawMessage without decoding it.
+func decodeRaw(buf *bytes.Buffer, t BuftiType, v reflect.Value) error {
+	data := buf.Bytes()
+	if err := skipValue(buf, t); err != nil {
+		return err
+	}
+
+	raw := make(RawMessage, len(data)-buf.Len())
+	copy(raw, data)
+	v.Set(reflect.ValueOf(raw))
+	return nil
+}
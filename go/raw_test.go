@@ -0,0 +1,109 @@
+package butil
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type RawNestedStruct struct {
+	ID       int64      `butil:"id"`
+	Simple   RawMessage `butil:"simple"`
+	Children RawMessage `butil:"children"`
+}
+
+func TestRawMessageRoundTrip(t *testing.T) {
+	original := NestedStruct{
+		ID:       1,
+		Simple:   SimpleStruct{ID: 2, Name: "simple", Age: 3, Rate: 0.5},
+		Children: []SimpleStruct{{ID: 4, Name: "a"}, {ID: 5, Name: "b"}},
+	}
+	encoded, err := nestedModel.Encode(original)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	var raw RawNestedStruct
+	if err := nestedModel.Decode(encoded, &raw); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if raw.ID != 1 || len(raw.Simple) == 0 || len(raw.Children) == 0 {
+		t.Fatalf("Expected raw fields to be set, got %+v", raw)
+	}
+
+	reencoded, err := nestedModel.Encode(raw)
+	if err != nil {
+		t.Fatalf("Encode of raw messages failed: %v", err)
+	}
+	if !bytes.Equal(encoded, reencoded) {
+		t.Errorf("Expected raw messages to be written verbatim\nexpected %x\ngot      %x", encoded, reencoded)
+	}
+
+	var simple SimpleStruct
+	if err := simpleModel.DecodeRaw(raw.Simple, &simple); err != nil {
+		t.Fatalf("DecodeRaw failed: %v", err)
+	}
+	if simple != original.Simple {
+		t.Errorf("Expected %+v, got %+v", original.Simple, simple)
+	}
+
+	var children []SimpleStruct
+	if err := raw.Children.Decode(List(Reference(simpleModel)), &children); err != nil {
+		t.Fatalf("Decode of raw list failed: %v", err)
+	}
+	if !reflect.DeepEqual(children, original.Children) {
+		t.Errorf("Expected %+v, got %+v", original.Children, children)
+	}
+}
+
+func TestRawMessageInMap(t *testing.T) {
+	raw, err := simpleModel.Encode(SimpleStruct{ID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := nestedModel.Encode(map[string]any{"simple": RawMessage(raw[4:])})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected, err := nestedModel.Encode(map[string]any{"simple": SimpleStruct{ID: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Expected %x, got %x", expected, encoded)
+	}
+}
+
+func TestRawMessageEmpty(t *testing.T) {
+	encoded, err := nestedModel.Encode(RawNestedStruct{ID: 1})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	var decoded NestedStruct
+	if err := nestedModel.Decode(encoded, &decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	expected := NestedStruct{ID: 1, Children: []SimpleStruct{}}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, decoded)
+	}
+}
+
+func TestRawMessageErrors(t *testing.T) {
+	if _, err := nestedModel.Encode(RawNestedStruct{Simple: RawMessage{1, 0, 0, 0}}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for a truncated raw message, got %v", err)
+	}
+	if _, err := nestedModel.Encode(RawNestedStruct{Children: RawMessage{0, 0, 0, 0, 0}}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for trailing bytes, got %v", err)
+	}
+	if err := simpleModel.DecodeRaw(RawMessage{0, 0, 0, 0, 1}, &SimpleStruct{}); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for trailing bytes, got %v", err)
+	}
+	if err := simpleModel.DecodeRaw(RawMessage{0, 0, 0, 0}, SimpleStruct{}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for a non-pointer destination, got %v", err)
+	}
+}
@@ -0,0 +1,568 @@
+package butil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Code is the status of an RPC call. Handlers fail calls with a code that is sent to the client.
+type Code uint16
+
+const (
+	CodeOK Code = iota
+	CodeCanceled
+	CodeUnknown
+	CodeInvalidArgument
+	CodeDeadlineExceeded
+	CodeNotFound
+	CodeInternal
+	CodeUnavailable
+)
+
+var codeNames = [...]string{"ok", "canceled", "unknown", "invalid argument", "deadline exceeded", "not found", "internal", "unavailable"}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", int(c))
+}
+
+// StatusError is the error of a failed RPC call. Handlers return it to choose the code the client receives,
+// and clients receive it for every call that failed on the server.
+type StatusError struct {
+	Code    Code
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("rpc error: %s: %s", e.Code, e.Message)
+}
+
+// Is reports whether the error matches context.Canceled or context.DeadlineExceeded by its code,
+// so that calls that failed on the server because of their context can be told apart.
+func (e *StatusError) Is(target error) bool {
+	return e.Code == CodeCanceled && target == context.Canceled ||
+		e.Code == CodeDeadlineExceeded && target == context.DeadlineExceeded
+}
+
+// Errorf returns a StatusError with the code and the formatted message.
+func Errorf(code Code, format string, args ...any) error {
+	return &StatusError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// StatusCode returns the code of an error: the code of a StatusError, CodeCanceled and CodeDeadlineExceeded
+// for the errors of a context, CodeOK for nil and CodeUnknown for any other error.
+func StatusCode(err error) Code {
+	var status *StatusError
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.As(err, &status):
+		return status.Code
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadlineExceeded
+	default:
+		return CodeUnknown
+	}
+}
+
+// Kinds of RPC frames.
+const (
+	rpcRequest   uint8 = iota + 1 // starts a call
+	rpcResponse                   // ends a call with a status and a response body
+	rpcCancel                     // cancels a call on the server
+	rpcStream                     // starts a streaming call
+	rpcMessage                    // carries a message of a stream in either direction
+	rpcCloseSend                  // ends the messages of the client in a stream
+	rpcWindow                     // allows the other side of a stream to send more messages
+)
+
+// rpcFrame is a message on an RPC connection. Every frame is encoded with rpcFrameModel,
+// and written with WriteFrame.
+type rpcFrame struct {
+	Kind     uint8  `butil:"kind"`
+	ID       uint64 `butil:"id"`
+	Method   string `butil:"method"`
+	Deadline int64  `butil:"deadline"` // in Unix nanoseconds, 0 if there is none
+	Code     uint16 `butil:"code"`
+	Message  string `butil:"message"`
+	Body     []byte `butil:"body"`
+	Credit   uint32 `butil:"credit"` // messages that a window frame allows
+}
+
+var rpcFrameModel = newModelWithOptions(
+	&ModelOptions{Name: "rpc frame"},
+	RequiredField(0, "kind", Uint8),
+	RequiredField(1, "id", Uint64),
+	Field(2, "method", String),
+	Field(3, "deadline", Int64),
+	Field(4, "code", Uint16),
+	Field(5, "message", String),
+	Field(6, "body", Bytes),
+	Field(7, "credit", Uint32),
+)
+
+// rpcConn reads and writes frames on a connection. Writes are safe for concurrent use.
+type rpcConn struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	w    *bufio.Writer
+	mu   sync.Mutex
+}
+
+func newRPCConn(conn io.ReadWriteCloser) *rpcConn {
+	return &rpcConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+}
+
+func (c *rpcConn) send(f *rpcFrame) error {
+	encoded, err := rpcFrameModel.Encode(f)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := WriteFrame(c.w, encoded, nil); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *rpcConn) receive() (*rpcFrame, error) {
+	encoded, err := ReadFrame(c.r, nil)
+	if err != nil {
+		return nil, err
+	}
+	f := new(rpcFrame)
+	if err := rpcFrameModel.Decode(encoded, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Client calls the methods of a Server over a single connection.
+// Calls can be made concurrently, and are multiplexed on the connection.
+type Client struct {
+	conn    *rpcConn
+	methods Methods
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *rpcFrame
+	streams map[uint64]*ClientStream
+	err     error
+	done    chan struct{}
+}
+
+// Dial connects to a Server at the address and returns a client for it. The methods hold
+// the models of the methods the client calls.
+func Dial(network, address string, methods Methods) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, methods), nil
+}
+
+// NewClient returns a client that makes calls over conn. The methods hold the models of the
+// methods the client calls.
+func NewClient(conn io.ReadWriteCloser, methods Methods) *Client {
+	c := &Client{
+		conn:    newRPCConn(conn),
+		methods: methods,
+		pending: make(map[uint64]chan *rpcFrame),
+		streams: make(map[uint64]*ClientStream),
+		done:    make(chan struct{}),
+	}
+	go c.receive()
+	return c
+}
+
+func (c *Client) receive() {
+	for {
+		f, err := c.conn.receive()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mu.Lock()
+		ch, exists := c.pending[f.ID]
+		delete(c.pending, f.ID)
+		st := c.streams[f.ID]
+		if f.Kind == rpcResponse {
+			delete(c.streams, f.ID)
+		}
+		c.mu.Unlock()
+
+		// Frames of calls that were canceled are dropped.
+		switch {
+		case exists:
+			ch <- f
+		case st != nil:
+			st.receive(f)
+		}
+	}
+}
+
+// fail ends all calls in flight after the connection failed or was closed.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		if err == io.EOF || errors.Is(err, ErrClosed) {
+			c.err = ErrClosed
+		} else {
+			c.err = fmt.Errorf("%w: %w", ErrClosed, err)
+		}
+		close(c.done)
+		for _, st := range c.streams {
+			st.cancel(c.err)
+		}
+	}
+}
+
+// start registers a new call and returns its ID and the channel its response is delivered to.
+func (c *Client) start() (uint64, chan *rpcFrame, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	c.nextID++
+	ch := make(chan *rpcFrame, 1)
+	c.pending[c.nextID] = ch
+	return c.nextID, ch, nil
+}
+
+func (c *Client) abandon(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// Call calls a method with the request and decodes the response into response, which has to be
+// a destination accepted by Model.Decode. The deadline of ctx is sent to the server, and the call
+// is canceled on the server if ctx is done before the response arrives.
+//
+// Returns ErrInput if the method is not in the methods of the client or the request cannot be encoded.
+// Returns a StatusError if the call failed on the server.
+// Returns the error of ctx if it is done before the response arrives.
+// Returns ErrClosed if the client is closed or the connection fails.
+func (c *Client) Call(ctx context.Context, method string, request, response any) error {
+	m, exists := c.methods[method]
+	if !exists {
+		return fmt.Errorf("%w: method %s is not registered", ErrInput, method)
+	}
+	body, err := m.Request.Encode(request)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	id, ch, err := c.start()
+	if err != nil {
+		return err
+	}
+	f := &rpcFrame{Kind: rpcRequest, ID: id, Method: method, Body: body}
+	if deadline, ok := ctx.Deadline(); ok {
+		f.Deadline = deadline.UnixNano()
+	}
+	if err := c.conn.send(f); err != nil {
+		c.abandon(id)
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+
+	select {
+	case f := <-ch:
+		if Code(f.Code) != CodeOK {
+			return &StatusError{Code: Code(f.Code), Message: f.Message}
+		}
+		return m.Response.Decode(f.Body, response)
+	case <-ctx.Done():
+		c.abandon(id)
+		_ = c.conn.send(&rpcFrame{Kind: rpcCancel, ID: id})
+		return ctx.Err()
+	case <-c.done:
+		return c.err
+	}
+}
+
+// Close closes the connection. Calls in flight fail with ErrClosed.
+func (c *Client) Close() error {
+	err := c.conn.conn.Close()
+	c.fail(ErrClosed)
+	return err
+}
+
+// Request is the request of a call that a handler serves.
+type Request struct {
+	// Method is the name of the called method.
+	Method string
+	body   []byte
+	model  *Model
+}
+
+// Decode decodes the request body into dest, which has to be a destination accepted by Model.Decode.
+// If the body cannot be decoded, it returns a StatusError with CodeInvalidArgument.
+func (r *Request) Decode(dest any) error {
+	if err := r.model.Decode(r.body, dest); err != nil {
+		return &StatusError{Code: CodeInvalidArgument, Message: err.Error()}
+	}
+	return nil
+}
+
+// HandlerFunc serves the calls of a method. It returns the response, which is encoded with the
+// response model of the method, or an error, whose code is sent to the client as by StatusCode.
+// The context is canceled when the client cancels the call, its deadline expires or the connection is closed.
+type HandlerFunc func(ctx context.Context, request *Request) (any, error)
+
+// Server serves calls of registered methods on any number of connections.
+type Server struct {
+	methods   Methods
+	handlers  map[string]HandlerFunc
+	streams   map[string]StreamHandlerFunc
+	listeners map[net.Listener]struct{}
+	conns     map[*rpcConn]struct{}
+	closed    bool
+	calls     sync.WaitGroup
+	mu        sync.RWMutex
+}
+
+// NewServer returns a server for the methods. Calls fail with CodeNotFound until a handler
+// is set for their method with Handle, or HandleStream for streaming calls.
+func NewServer(methods Methods) *Server {
+	return &Server{
+		methods:   methods,
+		handlers:  make(map[string]HandlerFunc),
+		streams:   make(map[string]StreamHandlerFunc),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*rpcConn]struct{}),
+	}
+}
+
+// Handle sets the handler of a method.
+//
+// Returns ErrInput if the method is not in the methods of the server.
+func (s *Server) Handle(method string, handler HandlerFunc) error {
+	if _, exists := s.methods[method]; !exists {
+		return fmt.Errorf("%w: method %s is not registered", ErrInput, method)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = handler
+	return nil
+}
+
+// Serve accepts connections on the listener and serves each of them in a new goroutine.
+// It returns ErrServerClosed after Shutdown or Close, and the error of the listener otherwise.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves calls on a single connection until it is closed, and then closes it.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	c := newRPCConn(conn)
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	calls := make(map[uint64]context.CancelFunc)
+	streams := make(map[uint64]*ServerStream)
+
+	defer func() {
+		cancel()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		f, err := c.receive()
+		if err != nil {
+			return
+		}
+
+		switch f.Kind {
+		case rpcRequest, rpcStream:
+			s.mu.RLock()
+			closed := s.closed
+			if !closed {
+				s.calls.Add(1)
+			}
+			s.mu.RUnlock()
+			if closed {
+				_ = c.send(&rpcFrame{Kind: rpcResponse, ID: f.ID, Code: uint16(CodeUnavailable), Message: "server is shutting down"})
+				continue
+			}
+
+			callCtx, callCancel := context.WithCancel(ctx)
+			if f.Deadline != 0 {
+				callCtx, callCancel = context.WithDeadline(ctx, time.Unix(0, f.Deadline))
+			}
+			var st *ServerStream
+			if f.Kind == rpcStream {
+				st = &ServerStream{Method: f.Method, stream: newStream(callCtx, c, f.ID)}
+			}
+			mu.Lock()
+			calls[f.ID] = callCancel
+			if st != nil {
+				streams[f.ID] = st
+			}
+			mu.Unlock()
+
+			go func() {
+				defer s.calls.Done()
+				defer func() {
+					mu.Lock()
+					delete(calls, f.ID)
+					delete(streams, f.ID)
+					mu.Unlock()
+					callCancel()
+				}()
+				if st != nil {
+					_ = c.send(s.serveStream(st))
+				} else {
+					_ = c.send(s.serve(callCtx, f))
+				}
+			}()
+
+		case rpcCancel:
+			mu.Lock()
+			if callCancel, exists := calls[f.ID]; exists {
+				callCancel()
+			}
+			mu.Unlock()
+
+		case rpcMessage, rpcCloseSend, rpcWindow:
+			mu.Lock()
+			st := streams[f.ID]
+			mu.Unlock()
+			if st != nil {
+				st.receive(f)
+			}
+		}
+	}
+}
+
+// serve runs the handler of a request frame and returns the response frame.
+func (s *Server) serve(ctx context.Context, f *rpcFrame) (response *rpcFrame) {
+	response = &rpcFrame{Kind: rpcResponse, ID: f.ID}
+
+	s.mu.RLock()
+	handler, exists := s.handlers[f.Method]
+	s.mu.RUnlock()
+	if !exists {
+		return response.fail(Errorf(CodeNotFound, "method %s not found", f.Method))
+	}
+	m := s.methods[f.Method]
+
+	defer func() {
+		if r := recover(); r != nil {
+			response.fail(Errorf(CodeInternal, "handler panicked: %v", r))
+		}
+	}()
+
+	result, err := handler(ctx, &Request{Method: f.Method, body: f.Body, model: m.Request})
+	if err != nil {
+		return response.fail(err)
+	}
+	if response.Body, err = m.Response.Encode(result); err != nil {
+		return response.fail(Errorf(CodeInternal, "failed to encode response: %v", err))
+	}
+	return response
+}
+
+// fail sets the status of a response frame to the code and message of err.
+func (f *rpcFrame) fail(err error) *rpcFrame {
+	f.Code = uint16(StatusCode(err))
+	f.Message = err.Error()
+	var status *StatusError
+	if errors.As(err, &status) {
+		f.Message = status.Message
+	}
+	f.Body = nil
+	return f
+}
+
+// Shutdown stops accepting connections and calls, waits for the calls in flight to finish and
+// then closes all connections. Calls that arrive in the meantime fail with CodeUnavailable.
+// If ctx is done first, the connections are closed right away and the error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.close(false)
+
+	done := make(chan struct{})
+	go func() {
+		s.calls.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.close(true)
+	return err
+}
+
+// Close closes all listeners and connections immediately. The contexts of calls in flight are canceled.
+func (s *Server) Close() error {
+	s.close(true)
+	return nil
+}
+
+func (s *Server) close(conns bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	if conns {
+		for c := range s.conns {
+			c.conn.Close()
+		}
+	}
+}
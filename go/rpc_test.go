@@ -0,0 +1,211 @@
+package butil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var rpcMethods = Methods{
+	"add":    {Request: arithArgsModel, Response: arithReplyModel},
+	"divide": {Request: arithArgsModel, Response: arithReplyModel},
+	"wait":   {Request: arithArgsModel, Response: arithReplyModel},
+	"panic":  {Request: arithArgsModel, Response: arithReplyModel},
+	"absent": {Request: arithArgsModel, Response: arithReplyModel},
+}
+
+// newArithServer returns a server whose "wait" method blocks until release is closed or the call is canceled,
+// and reports the error of its context on canceled.
+func newArithServer(t *testing.T, release <-chan struct{}, canceled chan<- error) *Server {
+	t.Helper()
+	server := NewServer(rpcMethods)
+
+	handlers := map[string]HandlerFunc{
+		"add": func(ctx context.Context, request *Request) (any, error) {
+			var args ArithArgs
+			if err := request.Decode(&args); err != nil {
+				return nil, err
+			}
+			return ArithReply{Result: args.A + args.B}, nil
+		},
+		"divide": func(ctx context.Context, request *Request) (any, error) {
+			var args ArithArgs
+			if err := request.Decode(&args); err != nil {
+				return nil, err
+			}
+			if args.B == 0 {
+				return nil, Errorf(CodeInvalidArgument, "division by zero")
+			}
+			return ArithReply{Result: args.A / args.B}, nil
+		},
+		"wait": func(ctx context.Context, request *Request) (any, error) {
+			select {
+			case <-release:
+				return ArithReply{Result: 1}, nil
+			case <-ctx.Done():
+				canceled <- ctx.Err()
+				return nil, ctx.Err()
+			}
+		},
+		"panic": func(ctx context.Context, request *Request) (any, error) {
+			panic("boom")
+		},
+	}
+	for method, handler := range handlers {
+		if err := server.Handle(method, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return server
+}
+
+func TestRPC(t *testing.T) {
+	server := newArithServer(t, nil, make(chan error, 1))
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := NewClient(clientConn, rpcMethods)
+	defer client.Close()
+
+	ctx := context.Background()
+	var reply ArithReply
+	if err := client.Call(ctx, "add", ArithArgs{A: 2, B: 3}, &reply); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if reply.Result != 5 {
+		t.Errorf("Expected 5, got %d", reply.Result)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply ArithReply
+			if err := client.Call(ctx, "add", ArithArgs{A: int64(i), B: 1}, &reply); err != nil {
+				t.Errorf("Call %d failed: %v", i, err)
+			} else if reply.Result != int64(i)+1 {
+				t.Errorf("Call %d: expected %d, got %d", i, i+1, reply.Result)
+			}
+		}()
+	}
+	wg.Wait()
+
+	tests := []struct {
+		method string
+		code   Code
+	}{
+		{method: "divide", code: CodeInvalidArgument},
+		{method: "absent", code: CodeNotFound},
+		{method: "panic", code: CodeInternal},
+	}
+	for _, tt := range tests {
+		err := client.Call(ctx, tt.method, ArithArgs{A: 1}, &reply)
+		var status *StatusError
+		if !errors.As(err, &status) || status.Code != tt.code {
+			t.Errorf("%s: expected code %s, got %v", tt.method, tt.code, err)
+		}
+	}
+	if err := client.Call(ctx, "unknown", ArithArgs{}, &reply); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unregistered method, got %v", err)
+	}
+	if err := server.Handle("unknown", nil); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unregistered handler, got %v", err)
+	}
+
+	client.Close()
+	if err := client.Call(ctx, "add", ArithArgs{}, &reply); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestRPCCancel(t *testing.T) {
+	canceled := make(chan error, 2)
+	server := newArithServer(t, nil, canceled)
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := NewClient(clientConn, rpcMethods)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var reply ArithReply
+	if err := client.Call(ctx, "wait", ArithArgs{}, &reply); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	// The deadline expires on the server at the same time as the client cancels the call.
+	if err := <-canceled; !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the handler to be canceled, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := client.Call(ctx, "wait", ArithArgs{}, &reply); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the handler to be canceled, got %v", err)
+	}
+
+	// The connection is still usable after canceled calls.
+	if err := client.Call(context.Background(), "add", ArithArgs{A: 1, B: 1}, &reply); err != nil || reply.Result != 2 {
+		t.Errorf("Expected 2, got %d, %v", reply.Result, err)
+	}
+}
+
+func TestRPCShutdown(t *testing.T) {
+	release := make(chan struct{})
+	server := newArithServer(t, release, make(chan error, 1))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	client, err := Dial("tcp", listener.Addr().String(), rpcMethods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	var reply ArithReply
+	if err := client.Call(ctx, "add", ArithArgs{A: 1, B: 2}, &reply); err != nil || reply.Result != 3 {
+		t.Fatalf("Expected 3, got %d, %v", reply.Result, err)
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		var reply ArithReply
+		waited <- client.Call(ctx, "wait", ArithArgs{}, &reply)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(ctx) }()
+
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed from Serve, got %v", err)
+	}
+	if err := client.Call(ctx, "add", ArithArgs{}, &reply); StatusCode(err) != CodeUnavailable {
+		t.Errorf("Expected CodeUnavailable during shutdown, got %v", err)
+	}
+
+	close(release)
+	if err := <-waited; err != nil {
+		t.Errorf("Expected the call in flight to finish, got %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if err := client.Call(ctx, "add", ArithArgs{}, &reply); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after shutdown, got %v", err)
+	}
+}
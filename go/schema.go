@@ -0,0 +1,364 @@
+package butil
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"text/scanner"
+	"unicode"
+)
+
+// ParseSchema parses a schema definition and returns the defined models by name.
+//
+//	model user {
+//		0 id: int64
+//		1 name: string
+//		optional 2 tags: list<string>
+//		optional 3 address: address
+//		optional encrypted 4 email: string
+//	}
+//
+//	model address {
+//		0 city: string
+//		optional 1 lines: map<uint8, string>
+//	}
+//
+// Fields are required unless marked optional, and fields marked encrypted are created as by EncryptedField.
+// Models may reference each other in any order, names that are not plain identifiers can be quoted
+// and `//` starts a comment.
+//
+// Returns ErrModel if the definition is malformed or inconsistent.
+func ParseSchema(src string) (map[string]*Model, error) {
+	p := &schemaParser{
+		models:   make(map[string]*Model),
+		declared: make(map[string]bool),
+	}
+	p.s.Init(strings.NewReader(src))
+	p.s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanStrings | scanner.ScanComments | scanner.SkipComments
+	p.s.Error = func(s *scanner.Scanner, msg string) {
+		if p.err == nil {
+			p.err = fmt.Errorf("%w: %s: %s", ErrModel, s.Position, msg)
+		}
+	}
+	p.next()
+
+	for p.tok != scanner.EOF {
+		if err := p.parseModel(); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, m := range p.models {
+		if !p.declared[name] {
+			return nil, fmt.Errorf("%w: model %s is referenced but not defined", ErrModel, name)
+		}
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return p.models, nil
+}
+
+type schemaParser struct {
+	s        scanner.Scanner
+	tok      rune
+	text     string
+	pos      scanner.Position
+	err      error
+	models   map[string]*Model
+	declared map[string]bool
+}
+
+func (p *schemaParser) next() {
+	p.tok = p.s.Scan()
+	p.text = p.s.TokenText()
+	p.pos = p.s.Position
+}
+
+func (p *schemaParser) errorf(format string, args ...any) error {
+	if p.err != nil {
+		return p.err
+	}
+	return fmt.Errorf("%w: %s: %s", ErrModel, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *schemaParser) describe() string {
+	if p.tok == scanner.EOF {
+		return "end of input"
+	}
+	return strconv.Quote(p.text)
+}
+
+func (p *schemaParser) expect(tok rune) error {
+	if p.tok != tok {
+		return p.errorf("expected %s, got %s", scanner.TokenString(tok), p.describe())
+	}
+	p.next()
+	return nil
+}
+
+// name reads an identifier or a quoted string.
+func (p *schemaParser) name(what string) (string, error) {
+	switch p.tok {
+	case scanner.Ident:
+		name := p.text
+		p.next()
+		return name, nil
+	case scanner.String:
+		name, err := strconv.Unquote(p.text)
+		if err != nil {
+			return "", p.errorf("invalid %s %s", what, p.text)
+		}
+		p.next()
+		return name, nil
+	default:
+		return "", p.errorf("expected %s, got %s", what, p.describe())
+	}
+}
+
+// model returns the model with the given name, creating it on first use so that
+// references can appear before the definition.
+func (p *schemaParser) model(name string) *Model {
+	m, exists := p.models[name]
+	if !exists {
+		m = &Model{
+			name:   name,
+			schema: make(map[byte]ModelField),
+			labels: make(map[string]byte),
+		}
+		p.models[name] = m
+	}
+	return m
+}
+
+func (p *schemaParser) parseModel() error {
+	if p.tok != scanner.Ident || p.text != "model" {
+		return p.errorf("expected \"model\", got %s", p.describe())
+	}
+	p.next()
+
+	name, err := p.name("model name")
+	if err != nil {
+		return err
+	}
+	if p.declared[name] {
+		return p.errorf("duplicate model %s", name)
+	}
+	p.declared[name] = true
+	m := p.model(name)
+
+	if err := p.expect('{'); err != nil {
+		return err
+	}
+	for p.tok != '}' {
+		if err := p.parseField(m); err != nil {
+			return err
+		}
+	}
+	p.next()
+	return nil
+}
+
+func (p *schemaParser) parseField(m *Model) error {
+	isRequired := true
+	if p.tok == scanner.Ident && (p.text == "optional" || p.text == "required") {
+		isRequired = p.text == "required"
+		p.next()
+	}
+	encrypted := false
+	if p.tok == scanner.Ident && p.text == "encrypted" {
+		encrypted = true
+		p.next()
+	}
+
+	if p.tok != scanner.Int {
+		return p.errorf("expected field index, got %s", p.describe())
+	}
+	index, err := strconv.ParseUint(p.text, 0, 8)
+	if err != nil {
+		return p.errorf("field index %s is not between 0 and 255", p.text)
+	}
+	p.next()
+
+	label, err := p.name("field label")
+	if err != nil {
+		return err
+	}
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	fieldType, err := p.parseType()
+	if err != nil {
+		return err
+	}
+	if p.tok == ';' || p.tok == ',' {
+		p.next()
+	}
+
+	if _, exists := m.schema[byte(index)]; exists {
+		return p.errorf("duplicate index %d in model %s", index, m.name)
+	}
+	if _, exists := m.labels[label]; exists {
+		return p.errorf("duplicate label %s in model %s", label, m.name)
+	}
+
+	m.addField(ModelField{
+		index:      byte(index),
+		label:      label,
+		fieldType:  fieldType,
+		isRequired: &isRequired,
+		encrypted:  encrypted,
+	})
+	return nil
+}
+
+func (p *schemaParser) parseType() (BuftiType, error) {
+	if p.tok == scanner.String {
+		name, err := p.name("model name")
+		if err != nil {
+			return nil, err
+		}
+		return Reference(p.model(name)), nil
+	}
+	if p.tok != scanner.Ident {
+		return nil, p.errorf("expected type, got %s", p.describe())
+	}
+
+	name := p.text
+	p.next()
+
+	switch name {
+	case "list":
+		if err := p.expect('<'); err != nil {
+			return nil, err
+		}
+		elementType, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect('>'); err != nil {
+			return nil, err
+		}
+		return List(elementType), nil
+
+	case "map":
+		if err := p.expect('<'); err != nil {
+			return nil, err
+		}
+		keyType, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		simpleKey, ok := keyType.(SimpleType)
+		if !ok || simpleKey == Bytes {
+			return nil, p.errorf("map keys have to be a simple type other than bytes, instead: %v", keyType)
+		}
+		if err := p.expect(','); err != nil {
+			return nil, err
+		}
+		valueType, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect('>'); err != nil {
+			return nil, err
+		}
+		return Map(simpleKey, valueType), nil
+	}
+
+	if name == "bool" {
+		return Bool, nil
+	}
+	if i := slices.Index(simpleTypeNames[:], name); i >= 0 {
+		return SimpleType(i), nil
+	}
+	return Reference(p.model(name)), nil
+}
+
+// FormatSchema renders models in the definition language read by ParseSchema.
+// Fields are written in index order.
+func FormatSchema(models ...*Model) string {
+	var sb strings.Builder
+	for i, m := range models {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		fmt.Fprintf(&sb, "model %s {\n", schemaName(m.name))
+
+		for _, index := range m.indices() {
+			field := m.schema[index]
+			sb.WriteString("  ")
+			if field.isRequired != nil && !*field.isRequired {
+				sb.WriteString("optional ")
+			}
+			fieldType := field.fieldType
+			if t, ok := fieldType.(encryptedType); ok {
+				sb.WriteString("encrypted ")
+				fieldType = t.fieldType
+			}
+			fmt.Fprintf(&sb, "%d %s: %s\n", index, schemaName(field.label), schemaType(fieldType))
+		}
+		sb.WriteString("}\n")
+	}
+	return sb.String()
+}
+
+func schemaType(t BuftiType) string {
+	switch t := t.(type) {
+	case SimpleType:
+		return simpleTypeNames[t]
+	case ListType:
+		return fmt.Sprintf("list<%s>", schemaType(t.elementType))
+	case MapType:
+		return fmt.Sprintf("map<%s, %s>", schemaType(t.keyType), schemaType(t.valueType))
+	case ReferenceType:
+		return schemaName(t.model.name)
+	default:
+		return strconv.Quote(fmt.Sprint(t))
+	}
+}
+
+// schemaName quotes names that would not be read back as a model name.
+func schemaName(name string) string {
+	if name == "" || name == "list" || name == "map" || name == "bool" || slices.Contains(simpleTypeNames[:], name) {
+		return strconv.Quote(name)
+	}
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return strconv.Quote(name)
+		}
+	}
+	return name
+}
+
+// Fingerprint returns a hash of the model schema and of all models it references.
+// Models with the same fingerprint produce and accept the same buffers.
+func (m *Model) Fingerprint() uint64 {
+	var models []*Model
+	var collect func(t BuftiType)
+	collect = func(t BuftiType) {
+		switch t := t.(type) {
+		case ListType:
+			collect(t.elementType)
+		case encryptedType:
+			collect(t.fieldType)
+		case MapType:
+			collect(t.valueType)
+		case ReferenceType:
+			if slices.Contains(models, t.model) {
+				return
+			}
+			models = append(models, t.model)
+			for _, index := range t.model.indices() {
+				collect(t.model.schema[index].fieldType)
+			}
+		}
+	}
+	collect(Reference(m))
+
+	sum := sha256.Sum256([]byte(FormatSchema(models...)))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,97 @@
+package butil
+
+import (
+	"errors"
+	"testing"
+)
+
+const testSchema = `
+// Users and their addresses.
+model user {
+  0 id: int64
+  1 name: string
+  optional 2 tags: list<string>
+  optional 3 address: address
+  optional 4 scores: map<uint8, list<float64>>
+}
+
+model address {
+  0 city: string
+  optional 1 "zip code": uint32
+}
+`
+
+func TestParseSchema(t *testing.T) {
+	models, err := ParseSchema(testSchema)
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+
+	user, exists := models["user"]
+	if !exists {
+		t.Fatal("Expected model user")
+	}
+	if !*user.schema[0].isRequired || *user.schema[2].isRequired {
+		t.Error("Required and optional fields not preserved")
+	}
+	if ref, ok := user.schema[3].fieldType.(ReferenceType); !ok || ref.model != models["address"] {
+		t.Errorf("Expected reference to address, got %v", user.schema[3].fieldType)
+	}
+
+	expected := `model user {
+  0 id: int64
+  1 name: string
+  optional 2 tags: list<string>
+  optional 3 address: address
+  optional 4 scores: map<uint8, list<float64>>
+}
+`
+	if formatted := FormatSchema(user); formatted != expected {
+		t.Errorf("Expected %q, got %q", expected, formatted)
+	}
+
+	reparsed, err := ParseSchema(FormatSchema(user, models["address"]))
+	if err != nil {
+		t.Fatalf("ParseSchema of formatted schema failed: %v", err)
+	}
+	if reparsed["user"].Fingerprint() != user.Fingerprint() {
+		t.Error("Fingerprint changed after formatting and parsing the schema")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a, err := ParseSchema(`model a { 0 id: int64 1 child: b } model b { 0 name: string }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseSchema(`model a { 0 id: int64 1 child: b } model b { 0 name: bytes }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a["a"].Fingerprint() == b["a"].Fingerprint() {
+		t.Error("Expected fingerprint to change with a referenced model")
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{name: "undefined_model", schema: `model a { 0 child: b }`},
+		{name: "duplicate_index", schema: `model a { 0 x: int8 0 y: int8 }`},
+		{name: "duplicate_label", schema: `model a { 0 x: int8 1 x: int8 }`},
+		{name: "index_out_of_range", schema: `model a { 256 x: int8 }`},
+		{name: "bytes_map_key", schema: `model a { 0 x: map<bytes, int8> }`},
+		{name: "unclosed_model", schema: `model a { 0 x: int8`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSchema(tt.schema); !errors.Is(err, ErrModel) {
+				t.Errorf("Expected ErrModel, got %v", err)
+			}
+		})
+	}
+}
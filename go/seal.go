@@ -0,0 +1,109 @@
+package butil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+)
+
+// KeyProvider looks up the keys that messages are sealed with.
+// Implementations can keep old keys available under their IDs, so that keys can be rotated
+// while messages sealed with them are still being read.
+type KeyProvider interface {
+	// Key returns the AES key with the given ID, which has to be 16, 24 or 32 bytes long.
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider that holds a fixed set of keys by their IDs.
+type StaticKeys map[uint32][]byte
+
+func (k StaticKeys) Key(id uint32) ([]byte, error) {
+	key, exists := k[id]
+	if !exists {
+		return nil, fmt.Errorf("unknown key %d", id)
+	}
+	return key, nil
+}
+
+// SealOptions configures how EncodeWithOptions seals a message.
+type SealOptions struct {
+	// Keys provides the key to seal with.
+	Keys KeyProvider
+	// KeyID is the ID of the key to seal with. It is stored in the message, so the key can be found when opening it.
+	KeyID uint32
+}
+
+// seal encrypts and authenticates the payload of a message with AES-GCM, and sets the sealed flag.
+// The sealed message is the header, the key ID as uint32, the nonce and the encrypted payload.
+// The header and key ID are authenticated as additional data.
+func seal(message []byte, options *SealOptions) ([]byte, error) {
+	if options.Keys == nil {
+		return nil, fmt.Errorf("%w: SealOptions.Keys is required", ErrInput)
+	}
+	key, err := options.Keys.Key(options.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get key %d: %w", ErrInput, options.KeyID, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInput, err)
+	}
+
+	payload := message[4:]
+	sealed := make([]byte, 8, 8+aead.NonceSize()+len(payload)+aead.Overhead())
+	binary.LittleEndian.PutUint32(sealed, binary.LittleEndian.Uint32(message)|flagSealed)
+	binary.LittleEndian.PutUint32(sealed[4:], options.KeyID)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The additional data must not overlap dst, so the header and key ID are copied out of it.
+	var additional [8]byte
+	copy(additional[:], sealed)
+	return aead.Seal(append(sealed, nonce...), nonce, payload, additional[:]), nil
+}
+
+// open authenticates and decrypts the payload of a sealed message with the given header.
+// Flags that are added after sealing are not part of the authenticated header.
+func open(header uint32, payload []byte, keys KeyProvider) ([]byte, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("%w: message is sealed, DecodeOptions.Keys is required", ErrInput)
+	}
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("%w: failed to read key ID", ErrBuffer)
+	}
+
+	id := binary.LittleEndian.Uint32(payload)
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get key %d: %w", ErrAuthentication, id, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthentication, err)
+	}
+
+	if len(payload) < 4+aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("%w: sealed payload is too short", ErrBuffer)
+	}
+	additional := binary.LittleEndian.AppendUint32(nil, header&(versionMask|flagSigned|flagFlate|flagGzip|flagSealed))
+	additional = append(additional, payload[:4]...)
+	nonce := payload[4 : 4+aead.NonceSize()]
+
+	plain, err := aead.Open(nil, nonce, payload[4+aead.NonceSize():], additional)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthentication, err)
+	}
+	return plain, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,99 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testKeys = StaticKeys{
+	1: bytes.Repeat([]byte{1}, 16),
+	2: bytes.Repeat([]byte{2}, 32),
+}
+
+func TestSeal(t *testing.T) {
+	original := compressibleStruct()
+
+	tests := []struct {
+		name    string
+		options *EncodeOptions
+	}{
+		{name: "seal", options: &EncodeOptions{Seal: &SealOptions{Keys: testKeys, KeyID: 1}}},
+		{name: "rotated_key", options: &EncodeOptions{Seal: &SealOptions{Keys: testKeys, KeyID: 2}}},
+		{name: "compressed", options: &EncodeOptions{Compression: Flate, Seal: &SealOptions{Keys: testKeys, KeyID: 1}}},
+		{name: "checksum", options: &EncodeOptions{Compression: Gzip, Seal: &SealOptions{Keys: testKeys, KeyID: 2}, Checksum: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := complexModel.EncodeWithOptions(original, tt.options)
+			if err != nil {
+				t.Fatalf("EncodeWithOptions failed: %v", err)
+			}
+			if bytes.Contains(sealed, []byte("compressed")) {
+				t.Error("Expected the payload to be encrypted")
+			}
+
+			var decoded ComplexStruct
+			if err := complexModel.DecodeWithOptions(sealed, &decoded, &DecodeOptions{Keys: testKeys}); err != nil {
+				t.Fatalf("DecodeWithOptions failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Error("Decoded value differs from the original")
+			}
+		})
+	}
+}
+
+func TestSealErrors(t *testing.T) {
+	sealed, err := simpleModel.EncodeWithOptions(SimpleStruct{ID: 1, Name: "secret"}, &EncodeOptions{Seal: &SealOptions{Keys: testKeys, KeyID: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := &DecodeOptions{Keys: testKeys}
+
+	var decoded SimpleStruct
+	for _, offset := range []int{4, 8, len(sealed) / 2, len(sealed) - 1} {
+		tampered := bytes.Clone(sealed)
+		tampered[offset] ^= 1
+		if err := simpleModel.DecodeWithOptions(tampered, &decoded, options); !errors.Is(err, ErrAuthentication) {
+			t.Errorf("Offset %d: expected ErrAuthentication, got %v", offset, err)
+		}
+	}
+
+	// Flags of the header are authenticated, so a message cannot be made to look compressed or signed.
+	for _, flag := range []uint32{flagFlate, flagGzip, flagSigned} {
+		tampered := bytes.Clone(sealed)
+		binary.LittleEndian.PutUint32(tampered, binary.LittleEndian.Uint32(tampered)|flag)
+		if err := simpleModel.DecodeWithOptions(tampered, &decoded, options); !errors.Is(err, ErrAuthentication) {
+			t.Errorf("Flag %#x: expected ErrAuthentication, got %v", flag, err)
+		}
+	}
+
+	wrongKey := StaticKeys{1: bytes.Repeat([]byte{3}, 16)}
+	if err := simpleModel.DecodeWithOptions(sealed, &decoded, &DecodeOptions{Keys: wrongKey}); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Expected ErrAuthentication for the wrong key, got %v", err)
+	}
+	if err := simpleModel.DecodeWithOptions(sealed, &decoded, &DecodeOptions{Keys: StaticKeys{}}); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Expected ErrAuthentication for an unknown key, got %v", err)
+	}
+	if err := simpleModel.Decode(sealed, &decoded); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput without keys, got %v", err)
+	}
+	if err := simpleModel.DecodeWithOptions(sealed[:20], &decoded, options); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a truncated message, got %v", err)
+	}
+
+	invalid := []*SealOptions{
+		{KeyID: 1},
+		{Keys: testKeys, KeyID: 3},
+		{Keys: StaticKeys{1: []byte("short")}, KeyID: 1},
+	}
+	for _, seal := range invalid {
+		if _, err := simpleModel.EncodeWithOptions(SimpleStruct{}, &EncodeOptions{Seal: seal}); !errors.Is(err, ErrInput) {
+			t.Errorf("Expected ErrInput for %+v, got %v", seal, err)
+		}
+	}
+}
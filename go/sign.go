@@ -0,0 +1,171 @@
+package butil
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Signature algorithms, as stored in the signature block.
+const (
+	signEd25519 byte = iota + 1
+	signHMAC
+)
+
+// SigningKey is a key that signs or verifies messages, with either Ed25519 or HMAC-SHA256.
+type SigningKey struct {
+	algorithm byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+	secret    []byte
+}
+
+// Ed25519Key returns a key that signs with an Ed25519 private key and verifies with its public key.
+func Ed25519Key(private ed25519.PrivateKey) SigningKey {
+	return SigningKey{algorithm: signEd25519, private: private, public: private.Public().(ed25519.PublicKey)}
+}
+
+// Ed25519PublicKey returns a key that can only verify Ed25519 signatures.
+func Ed25519PublicKey(public ed25519.PublicKey) SigningKey {
+	return SigningKey{algorithm: signEd25519, public: public}
+}
+
+// HMACKey returns a key that signs and verifies with HMAC-SHA256 and the given secret.
+func HMACKey(secret []byte) SigningKey {
+	return SigningKey{algorithm: signHMAC, secret: secret}
+}
+
+func (k SigningKey) sign(message []byte) ([]byte, error) {
+	switch {
+	case k.algorithm == signEd25519 && len(k.private) == ed25519.PrivateKeySize:
+		return ed25519.Sign(k.private, message), nil
+	case k.algorithm == signHMAC:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(message)
+		return mac.Sum(nil), nil
+	default:
+		return nil, fmt.Errorf("%w: key cannot sign", ErrInput)
+	}
+}
+
+func (k SigningKey) verify(message, signature []byte) bool {
+	switch k.algorithm {
+	case signEd25519:
+		return len(k.public) == ed25519.PublicKeySize && ed25519.Verify(k.public, message, signature)
+	case signHMAC:
+		expected, _ := k.sign(message)
+		return hmac.Equal(expected, signature)
+	default:
+		return false
+	}
+}
+
+// signatureSize returns the size of signatures of an algorithm, or 0 for unknown algorithms.
+func signatureSize(algorithm byte) int {
+	switch algorithm {
+	case signEd25519:
+		return ed25519.SignatureSize
+	case signHMAC:
+		return sha256.Size
+	default:
+		return 0
+	}
+}
+
+// Keyring holds the keys that signatures are verified with, by their key IDs.
+type Keyring map[uint32]SigningKey
+
+// SignOptions configures how EncodeWithOptions signs a message.
+type SignOptions struct {
+	// Key is the key to sign with.
+	Key SigningKey
+	// KeyID identifies the key in the keyring of the receiver. It is stored in the message.
+	KeyID uint32
+}
+
+// Verify checks that data carries a valid signature by a key of the keyring, and that the signed
+// payload is a canonical encoding for the model, meaning that it is exactly what Encode writes for
+// the value it holds. Compressed messages and checksums are handled as by Decode.
+//
+// Returns ErrSignature if the data is not signed, signed with an unknown key, or the signature is invalid.
+// Returns ErrVersion if the data was encoded with an incompatible protocol version.
+// Returns ErrChecksum if the data has a checksum that does not match.
+// Returns ErrBuffer if the data is corrupted or cannot be parsed.
+func Verify(model *Model, data []byte, keyring Keyring) error {
+	if keyring == nil {
+		keyring = Keyring{}
+	}
+	plain, err := unwrap(data, &DecodeOptions{Keyring: keyring})
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]any)
+	if err := model.Decode(plain, &fields); err != nil {
+		return err
+	}
+	canonical, err := model.Encode(fields)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBuffer, err)
+	}
+	if !bytes.Equal(plain, canonical) {
+		return fmt.Errorf("%w: signed payload is not canonical", ErrSignature)
+	}
+	return nil
+}
+
+// sign signs an encoded message and sets the signed flag. The signed message is the header,
+// the key ID as uint32, the algorithm as a byte, the payload and the signature of everything before it.
+// Signing comes before compression and sealing, so the signature covers the canonical encoding.
+func sign(message []byte, options *SignOptions) ([]byte, error) {
+	signed := make([]byte, 9, len(message)+5+signatureSize(options.Key.algorithm))
+	binary.LittleEndian.PutUint32(signed, binary.LittleEndian.Uint32(message)|flagSigned)
+	binary.LittleEndian.PutUint32(signed[4:], options.KeyID)
+	signed[8] = options.Key.algorithm
+	signed = append(signed, message[4:]...)
+
+	signature, err := options.Key.sign(signed)
+	if err != nil {
+		return nil, err
+	}
+	return append(signed, signature...), nil
+}
+
+// verifySignature checks the signature of the payload of a signed message with the given header,
+// and returns the payload without the signature block. If keyring is nil, the signature is removed
+// without being checked.
+func verifySignature(header uint32, payload []byte, keyring Keyring) ([]byte, error) {
+	if len(payload) < 5 {
+		return nil, fmt.Errorf("%w: failed to read signature block", ErrBuffer)
+	}
+	id, algorithm := binary.LittleEndian.Uint32(payload), payload[4]
+	size := signatureSize(algorithm)
+	if size == 0 {
+		return nil, fmt.Errorf("%w: unknown signature algorithm %d", ErrSignature, algorithm)
+	}
+	if len(payload) < 5+size {
+		return nil, fmt.Errorf("%w: failed to read signature", ErrBuffer)
+	}
+	end := len(payload) - size
+
+	if keyring != nil {
+		key, exists := keyring[id]
+		if !exists {
+			return nil, fmt.Errorf("%w: unknown key %d", ErrSignature, id)
+		}
+		if key.algorithm != algorithm {
+			return nil, fmt.Errorf("%w: key %d does not match the signature algorithm", ErrSignature, id)
+		}
+
+		// Flags that are added after signing are not part of the signed header.
+		signed := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+end), header&(versionMask|flagSigned))
+		signed = append(signed, payload[:end]...)
+		if !key.verify(signed, payload[end:]) {
+			return nil, fmt.Errorf("%w: signature by key %d does not match", ErrSignature, id)
+		}
+	}
+	return payload[5:end], nil
+}
@@ -0,0 +1,166 @@
+package butil
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testKeyring(t *testing.T) (Keyring, SigningKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{7}, ed25519.SeedSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := HMACKey([]byte("shared secret"))
+	return Keyring{1: Ed25519PublicKey(public), 2: secret}, Ed25519Key(private)
+}
+
+func TestSign(t *testing.T) {
+	keyring, private := testKeyring(t)
+	original := compressibleStruct()
+
+	tests := []struct {
+		name    string
+		options *EncodeOptions
+	}{
+		{name: "ed25519", options: &EncodeOptions{Sign: &SignOptions{Key: private, KeyID: 1}}},
+		{name: "hmac", options: &EncodeOptions{Sign: &SignOptions{Key: keyring[2], KeyID: 2}}},
+		{name: "compressed", options: &EncodeOptions{Compression: Flate, Sign: &SignOptions{Key: private, KeyID: 1}}},
+		{name: "sealed", options: &EncodeOptions{
+			Sign:     &SignOptions{Key: keyring[2], KeyID: 2},
+			Seal:     &SealOptions{Keys: testKeys, KeyID: 1},
+			Checksum: true,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, err := complexModel.EncodeWithOptions(original, tt.options)
+			if err != nil {
+				t.Fatalf("EncodeWithOptions failed: %v", err)
+			}
+
+			var decoded ComplexStruct
+			options := &DecodeOptions{Keys: testKeys, Keyring: keyring}
+			if err := complexModel.DecodeWithOptions(signed, &decoded, options); err != nil {
+				t.Fatalf("DecodeWithOptions failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Error("Decoded value differs from the original")
+			}
+
+			// Without a keyring the signature is not checked.
+			decoded = ComplexStruct{}
+			if err := complexModel.DecodeWithOptions(signed, &decoded, &DecodeOptions{Keys: testKeys}); err != nil {
+				t.Fatalf("DecodeWithOptions without keyring failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Error("Decoded value differs from the original")
+			}
+
+			if tt.options.Seal == nil {
+				if err := Verify(complexModel, signed, keyring); err != nil {
+					t.Errorf("Verify failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSignEncryptedFields(t *testing.T) {
+	keyring, private := testKeyring(t)
+	keyed := accountModel(testKeys, 1)
+	original := Account{ID: 1, Name: "ada", SSN: "078-05-1120", Card: []byte("4111111111111111")}
+	options := &EncodeOptions{Sign: &SignOptions{Key: private, KeyID: 1}}
+
+	signed, err := keyed.EncodeWithOptions(original, options)
+	if err != nil {
+		t.Fatalf("EncodeWithOptions failed: %v", err)
+	}
+	again, err := keyed.EncodeWithOptions(original, options)
+	if err != nil {
+		t.Fatalf("EncodeWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(signed, again) {
+		t.Error("Expected encrypted fields to encode deterministically")
+	}
+
+	// Verify re-encodes the payload, with and without the field keys.
+	if err := Verify(keyed, signed, keyring); err != nil {
+		t.Errorf("Verify with field keys failed: %v", err)
+	}
+	if err := Verify(accountModel(nil, 0), signed, keyring); err != nil {
+		t.Errorf("Verify without field keys failed: %v", err)
+	}
+}
+
+func TestSignErrors(t *testing.T) {
+	keyring, private := testKeyring(t)
+	value := SimpleStruct{ID: 1, Name: "signed"}
+	options := &DecodeOptions{Keyring: keyring}
+
+	signed, err := simpleModel.EncodeWithOptions(value, &EncodeOptions{Sign: &SignOptions{Key: private, KeyID: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded SimpleStruct
+	for _, offset := range []int{4, 8, 9, len(signed) / 2, len(signed) - 1} {
+		tampered := bytes.Clone(signed)
+		tampered[offset] ^= 1
+		if err := simpleModel.DecodeWithOptions(tampered, &decoded, options); !errors.Is(err, ErrSignature) {
+			t.Errorf("Offset %d: expected ErrSignature, got %v", offset, err)
+		}
+		if err := Verify(simpleModel, tampered, keyring); !errors.Is(err, ErrSignature) {
+			t.Errorf("Offset %d: expected ErrSignature from Verify, got %v", offset, err)
+		}
+	}
+
+	unsigned, err := simpleModel.Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := simpleModel.DecodeWithOptions(unsigned, &decoded, options); !errors.Is(err, ErrSignature) {
+		t.Errorf("Expected ErrSignature for an unsigned message, got %v", err)
+	}
+	if err := Verify(simpleModel, unsigned, keyring); !errors.Is(err, ErrSignature) {
+		t.Errorf("Expected ErrSignature from Verify for an unsigned message, got %v", err)
+	}
+	if err := Verify(simpleModel, signed, nil); !errors.Is(err, ErrSignature) {
+		t.Errorf("Expected ErrSignature for an empty keyring, got %v", err)
+	}
+
+	// A key ID that belongs to a key of another algorithm.
+	mismatched := bytes.Clone(signed)
+	binary.LittleEndian.PutUint32(mismatched[4:], 2)
+	if err := simpleModel.DecodeWithOptions(mismatched, &decoded, options); !errors.Is(err, ErrSignature) {
+		t.Errorf("Expected ErrSignature for a mismatched key, got %v", err)
+	}
+	if err := simpleModel.DecodeWithOptions(signed[:20], &decoded, options); !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected ErrBuffer for a truncated message, got %v", err)
+	}
+
+	// A payload with the fields out of order has a valid signature, but is not canonical.
+	reordered := binary.LittleEndian.AppendUint32(nil, ProtocolVersion)
+	reordered = binary.LittleEndian.AppendUint32(reordered, 2)
+	reordered = append(reordered, 1, 1, 0, 0, 0, 'a')
+	reordered = append(reordered, 0, 1, 0, 0, 0, 0, 0, 0, 0)
+	resigned, err := sign(reordered, &SignOptions{Key: keyring[2], KeyID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := simpleModel.DecodeWithOptions(resigned, &decoded, options); err != nil {
+		t.Errorf("DecodeWithOptions failed: %v", err)
+	}
+	if err := Verify(simpleModel, resigned, keyring); !errors.Is(err, ErrSignature) {
+		t.Errorf("Expected ErrSignature for a non-canonical payload, got %v", err)
+	}
+
+	if _, err := simpleModel.EncodeWithOptions(value, &EncodeOptions{Sign: &SignOptions{Key: keyring[1], KeyID: 1}}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for a public key, got %v", err)
+	}
+}
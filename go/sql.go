@@ -0,0 +1,102 @@
+package butil
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+)
+
+// ColumnModel names the model of a Column at the type level, so that the zero value of a column
+// knows how to decode into itself. It is usually implemented by an empty struct type:
+//
+//	type accountColumn struct{}
+//
+//	func (accountColumn) Model() *butil.Model { return accountModel }
+type ColumnModel interface {
+	Model() *Model
+}
+
+// Column holds a value that is stored encoded in a SQL BLOB column. It implements sql.Scanner and
+// driver.Valuer, so it can be passed to database/sql as an argument and a scan destination, or be
+// the type of a struct field that is persisted directly, such as Column[Account, accountColumn].
+//
+// T is the type that values are encoded from and decoded into, such as a struct or a map[string]any.
+// M provides the model the values are encoded with.
+type Column[T any, M ColumnModel] struct {
+	V T
+	// Valid is false if the column is NULL.
+	Valid bool
+}
+
+// NewColumn returns a valid column that holds a value encoded with the model of M.
+func NewColumn[M ColumnModel, T any](v T) Column[T, M] {
+	return Column[T, M]{V: v, Valid: true}
+}
+
+// columnModel returns the model of M.
+func columnModel[M ColumnModel]() (*Model, error) {
+	var m M
+	model := m.Model()
+	if model == nil {
+		return nil, fmt.Errorf("%w: column model %T returned nil", ErrModel, m)
+	}
+	return model, nil
+}
+
+// Scan implements sql.Scanner. It decodes a BLOB or text value into V, or sets Valid to false for NULL.
+//
+// Returns ErrModel if M returns no model.
+// Returns ErrInput if the value is neither a BLOB, text nor NULL.
+// Returns the errors of Model.Decode, naming the model of the column.
+func (c *Column[T, M]) Scan(src any) error {
+	model, err := columnModel[M]()
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+		var zero T
+		c.V, c.Valid = zero, false
+		return nil
+	case []byte:
+		// The driver may reuse src after Scan returns, and decoded byte slices share its memory.
+		data = bytes.Clone(src)
+	case string:
+		data = []byte(src)
+	default:
+		return fmt.Errorf("%w: cannot scan %T into a column of model %s", ErrInput, src, model.name)
+	}
+
+	var v T
+	if rv := reflect.ValueOf(&v).Elem(); rv.Kind() == reflect.Map {
+		rv.Set(reflect.MakeMap(rv.Type()))
+	}
+	if err := model.Decode(data, &v); err != nil {
+		return fmt.Errorf("failed to scan column of model %s: %w", model.name, err)
+	}
+	c.V, c.Valid = v, true
+	return nil
+}
+
+// Value implements driver.Valuer. It returns the encoded value as a []byte, or nil if the column is not valid.
+//
+// Returns ErrModel if M returns no model.
+// Returns the errors of Model.Encode, naming the model of the column.
+func (c Column[T, M]) Value() (driver.Value, error) {
+	if !c.Valid {
+		return nil, nil
+	}
+	model, err := columnModel[M]()
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := model.Encode(c.V)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write column of model %s: %w", model.name, err)
+	}
+	return encoded, nil
+}
@@ -0,0 +1,99 @@
+package butil
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type simpleColumn struct{}
+
+func (simpleColumn) Model() *Model { return simpleModel }
+
+type nilColumn struct{}
+
+func (nilColumn) Model() *Model { return nil }
+
+var (
+	_ sql.Scanner   = (*Column[SimpleStruct, simpleColumn])(nil)
+	_ driver.Valuer = Column[SimpleStruct, simpleColumn]{}
+)
+
+func TestColumn(t *testing.T) {
+	expected := SimpleStruct{ID: 4, Name: "row", Age: 50}
+	value, err := NewColumn[simpleColumn](expected).Value()
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+	blob, ok := value.([]byte)
+	if !ok {
+		t.Fatalf("Expected a []byte, got %T", value)
+	}
+
+	// Rows are scanned into zero struct fields.
+	var record struct {
+		Data Column[SimpleStruct, simpleColumn]
+	}
+	if err := record.Data.Scan(blob); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	// The driver may reuse its buffer after Scan.
+	clear(blob)
+	if !record.Data.Valid || record.Data.V != expected {
+		t.Errorf("Expected %+v, got %+v (valid %t)", expected, record.Data.V, record.Data.Valid)
+	}
+
+	if err := record.Data.Scan(nil); err != nil {
+		t.Fatalf("Scan of NULL failed: %v", err)
+	}
+	if record.Data.Valid || record.Data.V != (SimpleStruct{}) {
+		t.Errorf("Expected an invalid zero column for NULL, got %+v (valid %t)", record.Data.V, record.Data.Valid)
+	}
+	if value, err := record.Data.Value(); err != nil || value != nil {
+		t.Errorf("Expected NULL for an invalid column, got %v, %v", value, err)
+	}
+}
+
+func TestColumnMap(t *testing.T) {
+	value, err := NewColumn[simpleColumn](map[string]any{"id": int64(2), "name": "map"}).Value()
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+
+	var column Column[map[string]any, simpleColumn]
+	if err := column.Scan(value); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if column.V["name"] != "map" {
+		t.Errorf("Expected name map, got %v", column.V["name"])
+	}
+}
+
+func TestColumnErrors(t *testing.T) {
+	var unbound Column[SimpleStruct, nilColumn]
+	if err := unbound.Scan([]byte{}); !errors.Is(err, ErrModel) {
+		t.Errorf("Expected ErrModel for a column without model, got %v", err)
+	}
+	if _, err := NewColumn[nilColumn](SimpleStruct{}).Value(); !errors.Is(err, ErrModel) {
+		t.Errorf("Expected ErrModel for a column without model, got %v", err)
+	}
+
+	var column Column[SimpleStruct, simpleColumn]
+	if err := column.Scan(int64(1)); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an integer, got %v", err)
+	}
+
+	err := column.Scan([]byte{1, 0, 0})
+	if !errors.Is(err, ErrVersion) && !errors.Is(err, ErrBuffer) {
+		t.Errorf("Expected a decoding error for a corrupted blob, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "simple model") {
+		t.Errorf("Expected the error to name the model, got %v", err)
+	}
+
+	if _, err := NewColumn[simpleColumn](42).Value(); err == nil || !strings.Contains(err.Error(), "simple model") {
+		t.Errorf("Expected an encoding error naming the model, got %v", err)
+	}
+}
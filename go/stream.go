@@ -0,0 +1,337 @@
+package butil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// StreamWindow is the number of messages that each side of a stream may send before the other side
+// has received them. Send blocks while the window is used up, so a slow receiver holds back the sender
+// instead of buffering an unbounded number of messages.
+const StreamWindow = 32
+
+// stream holds what both sides of a streaming call share: the messages received from the other side,
+// and the credits for messages that may be sent to it.
+type stream struct {
+	conn     *rpcConn
+	id       uint64
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	incoming chan *rpcFrame
+	credits  chan struct{}
+	consumed int
+}
+
+func newStream(ctx context.Context, conn *rpcConn, id uint64) stream {
+	ctx, cancel := context.WithCancelCause(ctx)
+	s := stream{
+		conn:   conn,
+		id:     id,
+		ctx:    ctx,
+		cancel: cancel,
+		// The other side sends at most a window of messages and one frame that ends the stream.
+		incoming: make(chan *rpcFrame, StreamWindow+1),
+		credits:  make(chan struct{}, StreamWindow),
+	}
+	for range StreamWindow {
+		s.credits <- struct{}{}
+	}
+	return s
+}
+
+// receive handles a frame of the stream from the connection.
+func (s *stream) receive(f *rpcFrame) {
+	if f.Kind == rpcWindow {
+		for range f.Credit {
+			select {
+			case s.credits <- struct{}{}:
+			default:
+			}
+		}
+		return
+	}
+
+	select {
+	case s.incoming <- f:
+	default:
+		s.cancel(fmt.Errorf("%w: stream %d exceeded its window of %d messages", ErrBuffer, s.id, StreamWindow))
+	}
+}
+
+// next returns the next frame the other side sent, and gives credits back once half of the window was received.
+// Frames that were received before the stream was canceled are dropped.
+func (s *stream) next() (*rpcFrame, error) {
+	if s.ctx.Err() != nil {
+		return nil, context.Cause(s.ctx)
+	}
+
+	var f *rpcFrame
+	select {
+	case f = <-s.incoming:
+	case <-s.ctx.Done():
+		return nil, context.Cause(s.ctx)
+	}
+
+	if f.Kind == rpcMessage {
+		s.consumed++
+		if s.consumed >= StreamWindow/2 {
+			if err := s.conn.send(&rpcFrame{Kind: rpcWindow, ID: s.id, Credit: uint32(s.consumed)}); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrClosed, err)
+			}
+			s.consumed = 0
+		}
+	}
+	return f, nil
+}
+
+// send encodes a message and sends it once the window allows it. It stops waiting when ended is closed.
+func (s *stream) send(model *Model, message any, ended <-chan struct{}) error {
+	body, err := model.Encode(message)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ended:
+		return io.EOF
+	default:
+	}
+	if s.ctx.Err() != nil {
+		return context.Cause(s.ctx)
+	}
+
+	select {
+	case <-s.credits:
+	case <-s.ctx.Done():
+		return context.Cause(s.ctx)
+	case <-ended:
+		return io.EOF
+	}
+
+	if err := s.conn.send(&rpcFrame{Kind: rpcMessage, ID: s.id, Body: body}); err != nil {
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+	return nil
+}
+
+// ClientStream is the client side of a streaming call. Send and CloseSend may be called
+// concurrently with Recv, but not with each other.
+type ClientStream struct {
+	stream
+	method     Method
+	ended      chan struct{} // closed when the response of the server arrived
+	endOnce    sync.Once
+	response   *rpcFrame
+	sendClosed bool
+	stop       func() bool
+}
+
+// Stream starts a streaming call of a method. Requests are sent with Send and encoded with the
+// request model of the method, responses are received with Recv and decoded with its response model.
+// A call that streams responses for a single request sends it and calls CloseSend before receiving.
+//
+// The deadline of ctx is sent to the server, and the call is canceled on the server if ctx is done
+// before the call ends. Receive until Recv returns an error, or cancel ctx, to release the call.
+//
+// Returns ErrInput if the method is not in the methods of the client.
+// Returns ErrClosed if the client is closed or the connection fails.
+func (c *Client) Stream(ctx context.Context, method string) (*ClientStream, error) {
+	m, exists := c.methods[method]
+	if !exists {
+		return nil, fmt.Errorf("%w: method %s is not registered", ErrInput, method)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	st := &ClientStream{
+		stream: newStream(ctx, c.conn, c.nextID),
+		method: m,
+		ended:  make(chan struct{}),
+	}
+	c.streams[st.id] = st
+	c.mu.Unlock()
+
+	f := &rpcFrame{Kind: rpcStream, ID: st.id, Method: method}
+	if deadline, ok := ctx.Deadline(); ok {
+		f.Deadline = deadline.UnixNano()
+	}
+	if err := c.conn.send(f); err != nil {
+		c.abandonStream(st.id)
+		st.cancel(nil)
+		return nil, fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+
+	// Cancel the call on the server if the stream is canceled before the server ended it.
+	st.stop = context.AfterFunc(st.ctx, func() {
+		select {
+		case <-st.ended:
+		default:
+			c.abandonStream(st.id)
+			_ = c.conn.send(&rpcFrame{Kind: rpcCancel, ID: st.id})
+		}
+	})
+	return st, nil
+}
+
+func (c *Client) abandonStream(id uint64) {
+	c.mu.Lock()
+	delete(c.streams, id)
+	c.mu.Unlock()
+}
+
+func (s *ClientStream) receive(f *rpcFrame) {
+	s.stream.receive(f)
+	if f.Kind == rpcResponse {
+		s.endOnce.Do(func() { close(s.ended) })
+	}
+}
+
+// Send sends a request to the server. It blocks while the window of the stream is used up.
+//
+// Returns io.EOF if the server ended the call, whose status Recv returns.
+// Returns ErrInput if CloseSend was called or the request cannot be encoded.
+// Returns the cause of the cancellation if the stream was canceled.
+func (s *ClientStream) Send(request any) error {
+	if s.sendClosed {
+		return fmt.Errorf("%w: send on a stream that was closed with CloseSend", ErrInput)
+	}
+	return s.send(s.method.Request, request, s.ended)
+}
+
+// CloseSend tells the server that the client sends no more requests. Responses can still be received.
+func (s *ClientStream) CloseSend() error {
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	if err := s.conn.send(&rpcFrame{Kind: rpcCloseSend, ID: s.id}); err != nil {
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+	return nil
+}
+
+// Recv receives the next response and decodes it into response, which has to be a destination
+// accepted by Model.Decode.
+//
+// Returns io.EOF if the server ended the call successfully.
+// Returns a StatusError if the call failed on the server.
+// Returns the cause of the cancellation if the stream was canceled, such as the error of ctx or ErrClosed.
+func (s *ClientStream) Recv(response any) error {
+	if s.response != nil {
+		return s.status()
+	}
+
+	f, err := s.next()
+	if err != nil {
+		return err
+	}
+	if f.Kind == rpcResponse {
+		s.response = f
+		s.stop()
+		s.cancel(nil)
+		return s.status()
+	}
+	return s.method.Response.Decode(f.Body, response)
+}
+
+func (s *ClientStream) status() error {
+	if Code(s.response.Code) != CodeOK {
+		return &StatusError{Code: Code(s.response.Code), Message: s.response.Message}
+	}
+	return io.EOF
+}
+
+// ServerStream is the server side of a streaming call.
+type ServerStream struct {
+	stream
+	// Method is the name of the called method.
+	Method     string
+	method     Method
+	recvClosed bool
+}
+
+// Recv receives the next request and decodes it into request, which has to be a destination
+// accepted by Model.Decode.
+//
+// Returns io.EOF after the client called CloseSend.
+// Returns a StatusError with CodeInvalidArgument if the request cannot be decoded.
+// Returns the cause of the cancellation if the call was canceled.
+func (s *ServerStream) Recv(request any) error {
+	if s.recvClosed {
+		return io.EOF
+	}
+
+	f, err := s.next()
+	if err != nil {
+		return err
+	}
+	if f.Kind == rpcCloseSend {
+		s.recvClosed = true
+		return io.EOF
+	}
+	if err := s.method.Request.Decode(f.Body, request); err != nil {
+		return &StatusError{Code: CodeInvalidArgument, Message: err.Error()}
+	}
+	return nil
+}
+
+// Send sends a response to the client. It blocks while the window of the stream is used up.
+//
+// Returns ErrInput if the response cannot be encoded.
+// Returns the cause of the cancellation if the call was canceled.
+func (s *ServerStream) Send(response any) error {
+	return s.send(s.method.Response, response, nil)
+}
+
+// StreamHandlerFunc serves the streaming calls of a method. It receives requests and sends responses
+// on the stream, and returns when the call is done. The returned error is sent to the client as by
+// StatusCode, after all responses. The context is canceled when the client cancels the call, its
+// deadline expires or the connection is closed.
+type StreamHandlerFunc func(ctx context.Context, stream *ServerStream) error
+
+// HandleStream sets the handler of a method for streaming calls.
+//
+// Returns ErrInput if the method is not in the methods of the server.
+func (s *Server) HandleStream(method string, handler StreamHandlerFunc) error {
+	if _, exists := s.methods[method]; !exists {
+		return fmt.Errorf("%w: method %s is not registered", ErrInput, method)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[method] = handler
+	return nil
+}
+
+// serveStream runs the handler of a stream and returns the response frame that ends it.
+func (s *Server) serveStream(st *ServerStream) (response *rpcFrame) {
+	response = &rpcFrame{Kind: rpcResponse, ID: st.id}
+	defer st.cancel(nil)
+
+	s.mu.RLock()
+	handler, exists := s.streams[st.Method]
+	s.mu.RUnlock()
+	if !exists {
+		return response.fail(Errorf(CodeNotFound, "streaming method %s not found", st.Method))
+	}
+	st.method = s.methods[st.Method]
+
+	defer func() {
+		if r := recover(); r != nil {
+			response.fail(Errorf(CodeInternal, "handler panicked: %v", r))
+		}
+	}()
+
+	if err := handler(st.ctx, st); err != nil {
+		return response.fail(err)
+	}
+	return response
+}
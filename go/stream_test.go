@@ -0,0 +1,227 @@
+package butil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var streamMethods = Methods{
+	"count": {Request: arithArgsModel, Response: arithReplyModel},
+	"sum":   {Request: arithArgsModel, Response: arithReplyModel},
+	"fail":  {Request: arithArgsModel, Response: arithReplyModel},
+	"add":   {Request: arithArgsModel, Response: arithReplyModel},
+}
+
+// newStreamClient serves the streaming methods on one end of a pipe and returns a client for the other.
+// "count" streams A responses for a single request, reporting how many were sent on sent and the
+// error that ended the handler on ended.
+func newStreamClient(t *testing.T, sent *atomic.Int64, ended chan<- error) *Client {
+	t.Helper()
+	server := NewServer(streamMethods)
+
+	handlers := map[string]StreamHandlerFunc{
+		"count": func(ctx context.Context, stream *ServerStream) error {
+			var args ArithArgs
+			if err := stream.Recv(&args); err != nil {
+				return err
+			}
+			for i := range args.A {
+				if err := stream.Send(ArithReply{Result: i}); err != nil {
+					ended <- err
+					return err
+				}
+				sent.Add(1)
+			}
+			ended <- nil
+			return nil
+		},
+		"sum": func(ctx context.Context, stream *ServerStream) error {
+			for {
+				var args ArithArgs
+				err := stream.Recv(&args)
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err := stream.Send(ArithReply{Result: args.A + args.B}); err != nil {
+					return err
+				}
+			}
+		},
+		"fail": func(ctx context.Context, stream *ServerStream) error {
+			if err := stream.Send(ArithReply{Result: 1}); err != nil {
+				return err
+			}
+			return Errorf(CodeInvalidArgument, "failed after one response")
+		},
+	}
+	for method, handler := range handlers {
+		if err := server.HandleStream(method, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := NewClient(clientConn, streamMethods)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestStreamResponses(t *testing.T) {
+	var sent atomic.Int64
+	client := newStreamClient(t, &sent, make(chan error, 1))
+
+	stream, err := client.Stream(context.Background(), "count")
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if err := stream.Send(ArithArgs{A: 1000}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend failed: %v", err)
+	}
+	if err := stream.Send(ArithArgs{}); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput after CloseSend, got %v", err)
+	}
+
+	for i := range int64(1000) {
+		var reply ArithReply
+		if err := stream.Recv(&reply); err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+		if reply.Result != i {
+			t.Fatalf("Expected %d, got %d", i, reply.Result)
+		}
+	}
+	var reply ArithReply
+	if err := stream.Recv(&reply); err != io.EOF {
+		t.Errorf("Expected io.EOF at the end of the stream, got %v", err)
+	}
+	if err := stream.Recv(&reply); err != io.EOF {
+		t.Errorf("Expected io.EOF again, got %v", err)
+	}
+}
+
+func TestStreamBidirectional(t *testing.T) {
+	client := newStreamClient(t, new(atomic.Int64), make(chan error, 1))
+
+	stream, err := client.Stream(context.Background(), "sum")
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	// Requests are sent while responses are received, beyond the window of either side.
+	go func() {
+		for i := range int64(200) {
+			if err := stream.Send(ArithArgs{A: i, B: i}); err != nil {
+				t.Errorf("Send %d failed: %v", i, err)
+				return
+			}
+		}
+		stream.CloseSend()
+	}()
+
+	for i := range int64(200) {
+		var reply ArithReply
+		if err := stream.Recv(&reply); err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+		if reply.Result != 2*i {
+			t.Fatalf("Expected %d, got %d", 2*i, reply.Result)
+		}
+	}
+	var reply ArithReply
+	if err := stream.Recv(&reply); err != io.EOF {
+		t.Errorf("Expected io.EOF at the end of the stream, got %v", err)
+	}
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	var sent atomic.Int64
+	ended := make(chan error, 1)
+	client := newStreamClient(t, &sent, ended)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Stream(ctx, "count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(ArithArgs{A: 1000})
+	stream.CloseSend()
+
+	// Without receiving, the server can only send a window of messages.
+	time.Sleep(50 * time.Millisecond)
+	if n := sent.Load(); n != StreamWindow {
+		t.Errorf("Expected %d messages to be sent before the client receives, got %d", StreamWindow, n)
+	}
+
+	var reply ArithReply
+	for range StreamWindow {
+		if err := stream.Recv(&reply); err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := sent.Load(); n <= StreamWindow || n > 2*StreamWindow {
+		t.Errorf("Expected the window to move on after receiving, got %d messages", n)
+	}
+
+	cancel()
+	if err := <-ended; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the handler to be canceled, got %v", err)
+	}
+	if err := stream.Recv(&reply); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Recv, got %v", err)
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	client := newStreamClient(t, new(atomic.Int64), make(chan error, 1))
+	ctx := context.Background()
+
+	stream, err := client.Stream(ctx, "fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply ArithReply
+	if err := stream.Recv(&reply); err != nil || reply.Result != 1 {
+		t.Errorf("Expected the response before the error, got %d, %v", reply.Result, err)
+	}
+	if err := stream.Recv(&reply); StatusCode(err) != CodeInvalidArgument {
+		t.Errorf("Expected CodeInvalidArgument, got %v", err)
+	}
+	if err := stream.Send(ArithArgs{}); err != io.EOF {
+		t.Errorf("Expected io.EOF from Send after the call ended, got %v", err)
+	}
+
+	// Methods without a stream handler fail with CodeNotFound.
+	stream, err = client.Stream(ctx, "add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Recv(&reply); StatusCode(err) != CodeNotFound {
+		t.Errorf("Expected CodeNotFound, got %v", err)
+	}
+	if _, err := client.Stream(ctx, "unknown"); !errors.Is(err, ErrInput) {
+		t.Errorf("Expected ErrInput for an unregistered method, got %v", err)
+	}
+
+	stream, err = client.Stream(ctx, "sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if err := stream.Recv(&reply); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
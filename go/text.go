@@ -0,0 +1,596 @@
+package butil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Format renders an encoded buffer as human-readable text according to the model schema.
+// Fields are written one per line as `label: value`, in the order they appear in the buffer.
+// Nested models, lists and maps are indented by two spaces per level. Encrypted fields are written
+// as `encrypted` followed by their encrypted bytes, without being decrypted.
+//
+//	id: 12
+//	name: "x"
+//	tags: ["a", "b"]
+//	data: b"\xde\xad"
+//
+// Returns ErrVersion if the data was encoded with an incompatible protocol version.
+// Returns ErrBuffer if the data is corrupted or cannot be parsed.
+func Format(model *Model, data []byte) (string, error) {
+	data, err := unwrap(data, nil)
+	if err != nil {
+		return "", err
+	}
+
+	buf := bytes.NewBuffer(data)
+	if err := readVersion(buf); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err := formatFields(&sb, buf, model, 0); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func formatFields(sb *strings.Builder, buf *bytes.Buffer, m *Model, depth int) error {
+	var fieldCount uint32
+	if err := binary.Read(buf, binary.LittleEndian, &fieldCount); err != nil {
+		return fmt.Errorf("%w: failed to read field count: %w", ErrBuffer, err)
+	}
+
+	for range fieldCount {
+		index, err := buf.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: failed to read field index: %w", ErrBuffer, err)
+		}
+
+		schemaField, exists := m.schema[index]
+		if !exists {
+			return fmt.Errorf("%w: index %d does not exist on model %s", ErrBuffer, index, m.name)
+		}
+
+		writeIndent(sb, depth)
+		sb.WriteString(formatLabel(schemaField.label))
+		sb.WriteString(": ")
+		if err := formatValue(sb, buf, schemaField.fieldType, depth); err != nil {
+			return err
+		}
+		sb.WriteByte('\n')
+	}
+	return nil
+}
+
+func formatValue(sb *strings.Builder, buf *bytes.Buffer, t BuftiType, depth int) error {
+	switch t := t.(type) {
+	case SimpleType:
+		var v any
+		if err := t.Decode(buf, reflect.ValueOf(&v).Elem()); err != nil {
+			return err
+		}
+		sb.WriteString(formatScalar(v))
+
+	case ListType:
+		var length uint32
+		if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+			return fmt.Errorf("%w: failed to decode list length: %w", ErrBuffer, err)
+		}
+		_, inline := t.elementType.(SimpleType)
+
+		sb.WriteByte('[')
+		for i := range length {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			if inline {
+				if i > 0 {
+					sb.WriteByte(' ')
+				}
+			} else {
+				sb.WriteByte('\n')
+				writeIndent(sb, depth+1)
+			}
+			if err := formatValue(sb, buf, t.elementType, depth+1); err != nil {
+				return err
+			}
+		}
+		if !inline && length > 0 {
+			sb.WriteByte('\n')
+			writeIndent(sb, depth)
+		}
+		sb.WriteByte(']')
+
+	case MapType:
+		var length uint32
+		if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+			return fmt.Errorf("%w: failed to decode map length: %w", ErrBuffer, err)
+		}
+		_, inline := t.valueType.(SimpleType)
+
+		sb.WriteByte('{')
+		for i := range length {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			if inline {
+				if i > 0 {
+					sb.WriteByte(' ')
+				}
+			} else {
+				sb.WriteByte('\n')
+				writeIndent(sb, depth+1)
+			}
+			if err := formatValue(sb, buf, t.keyType, depth+1); err != nil {
+				return err
+			}
+			sb.WriteString(": ")
+			if err := formatValue(sb, buf, t.valueType, depth+1); err != nil {
+				return err
+			}
+		}
+		if !inline && length > 0 {
+			sb.WriteByte('\n')
+			writeIndent(sb, depth)
+		}
+		sb.WriteByte('}')
+
+	case encryptedType:
+		var sealed any
+		if err := Bytes.Decode(buf, reflect.ValueOf(&sealed).Elem()); err != nil {
+			return err
+		}
+		sb.WriteString("encrypted ")
+		sb.WriteString(formatScalar(sealed))
+
+	case ReferenceType:
+		sb.WriteString("{\n")
+		if err := formatFields(sb, buf, t.model, depth+1); err != nil {
+			return err
+		}
+		writeIndent(sb, depth)
+		sb.WriteByte('}')
+
+	default:
+		return fmt.Errorf("%w: cannot format values of type %T", ErrModel, t)
+	}
+	return nil
+}
+
+func formatScalar(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []byte:
+		return "b" + quoteBytes(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// quoteBytes quotes b as a string literal that escapes every byte outside of printable ASCII.
+func quoteBytes(b []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "\\x%02x", c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// formatLabel quotes labels that could not be read back as a bare word.
+func formatLabel(label string) string {
+	if label == "" || strings.ContainsFunc(label, isDelimiter) {
+		return strconv.Quote(label)
+	}
+	return label
+}
+
+func writeIndent(sb *strings.Builder, depth int) {
+	for range depth {
+		sb.WriteString("  ")
+	}
+}
+
+func isDelimiter(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(`:,[]{}"#`, r)
+}
+
+// Parse reads the text representation produced by Format and encodes it according to the model schema.
+// Fields, list elements and map entries are written in the order they appear in the text,
+// so formatting a buffer and parsing the result yields the exact same bytes.
+// Commas between values are optional and `#` starts a comment that runs to the end of the line.
+// Encrypted fields accept the encrypted bytes written by Format as well as plain values,
+// which are encrypted with the field keys of their model.
+//
+// Returns ErrInput if the text is malformed or does not match the model schema.
+func Parse(model *Model, text string) ([]byte, error) {
+	p := &textParser{src: text}
+
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, ProtocolVersion); err != nil {
+		return nil, fmt.Errorf("failed to write protocol version")
+	}
+	if err := p.parseFields(buf, model, ""); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenWord
+	tokenString
+	tokenBytes
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type textParser struct {
+	src    string
+	pos    int
+	peeked *token
+}
+
+func (p *textParser) errorf(tok token, format string, args ...any) error {
+	line := strings.Count(p.src[:tok.pos], "\n") + 1
+	column := tok.pos - strings.LastIndexByte(p.src[:tok.pos], '\n')
+	return fmt.Errorf("%w: line %d column %d: %s", ErrInput, line, column, fmt.Sprintf(format, args...))
+}
+
+func (p *textParser) peek() (token, error) {
+	if p.peeked == nil {
+		tok, err := p.scan()
+		if err != nil {
+			return token{}, err
+		}
+		p.peeked = &tok
+	}
+	return *p.peeked, nil
+}
+
+func (p *textParser) next() (token, error) {
+	tok, err := p.peek()
+	p.peeked = nil
+	return tok, err
+}
+
+func (p *textParser) expect(punct string) error {
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	if tok.kind != tokenPunct || tok.text != punct {
+		return p.errorf(tok, "expected %q, got %s", punct, tok.describe())
+	}
+	return nil
+}
+
+func (p *textParser) scan() (token, error) {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if !unicode.IsSpace(rune(c)) {
+			break
+		}
+		p.pos++
+	}
+
+	start := p.pos
+	if start == len(p.src) {
+		return token{kind: tokenEOF, pos: start}, nil
+	}
+
+	switch c := p.src[start]; {
+	case strings.IndexByte(":,[]{}", c) >= 0:
+		p.pos++
+		return token{kind: tokenPunct, text: string(c), pos: start}, nil
+	case c == '"':
+		s, err := p.scanQuoted(start)
+		return token{kind: tokenString, text: s, pos: start}, err
+	case c == 'b' && start+1 < len(p.src) && p.src[start+1] == '"':
+		s, err := p.scanQuoted(start + 1)
+		return token{kind: tokenBytes, text: s, pos: start}, err
+	}
+
+	end := strings.IndexFunc(p.src[start:], isDelimiter)
+	if end < 0 {
+		end = len(p.src) - start
+	}
+	p.pos = start + end
+	return token{kind: tokenWord, text: p.src[start:p.pos], pos: start}, nil
+}
+
+func (p *textParser) scanQuoted(start int) (string, error) {
+	i := start + 1
+	for i < len(p.src) && p.src[i] != '"' && p.src[i] != '\n' {
+		if p.src[i] == '\\' {
+			i++
+		}
+		i++
+	}
+	if i >= len(p.src) || p.src[i] != '"' {
+		return "", p.errorf(token{pos: start}, "unterminated string")
+	}
+
+	p.pos = i + 1
+	s, err := strconv.Unquote(p.src[start:p.pos])
+	if err != nil {
+		return "", p.errorf(token{pos: start}, "invalid string: %v", err)
+	}
+	return s, nil
+}
+
+func (tok token) describe() string {
+	switch tok.kind {
+	case tokenEOF:
+		return "end of input"
+	case tokenString, tokenBytes:
+		return "string"
+	default:
+		return strconv.Quote(tok.text)
+	}
+}
+
+// parseFields parses `label: value` pairs up to the closing token and writes them with a leading field count.
+// An empty closing token means the fields run until the end of the input.
+func (p *textParser) parseFields(buf *bytes.Buffer, m *Model, closing string) error {
+	var fields bytes.Buffer
+	var fieldCount uint32
+
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if tok.kind == tokenEOF {
+			if closing != "" {
+				return p.errorf(tok, "expected %q, got end of input", closing)
+			}
+			break
+		}
+		if tok.kind == tokenPunct && tok.text == closing {
+			break
+		}
+		if tok.kind == tokenPunct && tok.text == "," {
+			continue
+		}
+		if tok.kind != tokenWord && tok.kind != tokenString {
+			return p.errorf(tok, "expected field label, got %s", tok.describe())
+		}
+
+		index, exists := m.labels[tok.text]
+		if !exists {
+			return p.errorf(tok, "field %s not found in model %s", tok.text, m.name)
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+
+		fields.WriteByte(index)
+		if err := p.parseValue(&fields, m.schema[index].fieldType); err != nil {
+			return err
+		}
+		fieldCount++
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, fieldCount); err != nil {
+		return err
+	}
+	_, err := buf.Write(fields.Bytes())
+	return err
+}
+
+func (p *textParser) parseValue(buf *bytes.Buffer, t BuftiType) error {
+	switch t := t.(type) {
+	case SimpleType:
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		v, err := p.parseScalar(t, tok)
+		if err != nil {
+			return err
+		}
+		return t.Encode(buf, reflect.ValueOf(v))
+
+	case ListType:
+		if err := p.expect("["); err != nil {
+			return err
+		}
+
+		var elements bytes.Buffer
+		var length uint32
+		for {
+			tok, err := p.peek()
+			if err != nil {
+				return err
+			}
+			if tok.kind == tokenPunct && tok.text == "]" {
+				p.next()
+				break
+			}
+			if tok.kind == tokenPunct && tok.text == "," {
+				p.next()
+				continue
+			}
+			if err := p.parseValue(&elements, t.elementType); err != nil {
+				return err
+			}
+			length++
+		}
+
+		if err := binary.Write(buf, binary.LittleEndian, length); err != nil {
+			return err
+		}
+		_, err := buf.Write(elements.Bytes())
+		return err
+
+	case MapType:
+		if err := p.expect("{"); err != nil {
+			return err
+		}
+
+		var entries bytes.Buffer
+		var length uint32
+		for {
+			tok, err := p.peek()
+			if err != nil {
+				return err
+			}
+			if tok.kind == tokenPunct && tok.text == "}" {
+				p.next()
+				break
+			}
+			if tok.kind == tokenPunct && tok.text == "," {
+				p.next()
+				continue
+			}
+			if err := p.parseValue(&entries, t.keyType); err != nil {
+				return err
+			}
+			if err := p.expect(":"); err != nil {
+				return err
+			}
+			if err := p.parseValue(&entries, t.valueType); err != nil {
+				return err
+			}
+			length++
+		}
+
+		if err := binary.Write(buf, binary.LittleEndian, length); err != nil {
+			return err
+		}
+		_, err := buf.Write(entries.Bytes())
+		return err
+
+	case ReferenceType:
+		if err := p.expect("{"); err != nil {
+			return err
+		}
+		return p.parseFields(buf, t.model, "}")
+
+	case encryptedType:
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if tok.kind != tokenWord || tok.text != "encrypted" {
+			var plain bytes.Buffer
+			if err := p.parseValue(&plain, t.fieldType); err != nil {
+				return err
+			}
+			return t.encrypt(buf, plain.Bytes())
+		}
+		p.next()
+		tok, err = p.next()
+		if err != nil {
+			return err
+		}
+		if tok.kind != tokenBytes {
+			return p.errorf(tok, "expected bytes, got %s", tok.describe())
+		}
+		return t.Encode(buf, reflect.ValueOf(EncryptedValue(tok.text)))
+
+	default:
+		return fmt.Errorf("%w: cannot parse values of type %T", ErrModel, t)
+	}
+}
+
+func (p *textParser) parseScalar(t SimpleType, tok token) (any, error) {
+	switch t {
+	case String:
+		if tok.kind != tokenString {
+			return nil, p.errorf(tok, "expected string, got %s", tok.describe())
+		}
+		return tok.text, nil
+	case Bytes:
+		if tok.kind != tokenBytes {
+			return nil, p.errorf(tok, "expected bytes, got %s", tok.describe())
+		}
+		return []byte(tok.text), nil
+	}
+
+	if tok.kind != tokenWord {
+		return nil, p.errorf(tok, "expected %s, got %s", t, tok.describe())
+	}
+
+	var v any
+	var err error
+	switch t {
+	case Bool:
+		v, err = strconv.ParseBool(tok.text)
+	case Int8:
+		var n int64
+		n, err = strconv.ParseInt(tok.text, 0, 8)
+		v = int8(n)
+	case Int16:
+		var n int64
+		n, err = strconv.ParseInt(tok.text, 0, 16)
+		v = int16(n)
+	case Int32:
+		var n int64
+		n, err = strconv.ParseInt(tok.text, 0, 32)
+		v = int32(n)
+	case Int64:
+		v, err = strconv.ParseInt(tok.text, 0, 64)
+	case Uint8:
+		var n uint64
+		n, err = strconv.ParseUint(tok.text, 0, 8)
+		v = uint8(n)
+	case Uint16:
+		var n uint64
+		n, err = strconv.ParseUint(tok.text, 0, 16)
+		v = uint16(n)
+	case Uint32:
+		var n uint64
+		n, err = strconv.ParseUint(tok.text, 0, 32)
+		v = uint32(n)
+	case Uint64:
+		v, err = strconv.ParseUint(tok.text, 0, 64)
+	case Float32:
+		var f float64
+		f, err = strconv.ParseFloat(tok.text, 32)
+		v = float32(f)
+	case Float64:
+		v, err = strconv.ParseFloat(tok.text, 64)
+	default:
+		return nil, fmt.Errorf("%w: unknown SimpleType: %d", ErrModel, t)
+	}
+
+	if err != nil {
+		return nil, p.errorf(tok, "invalid %s %s", t, tok.text)
+	}
+	return v, nil
+}
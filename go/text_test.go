@@ -0,0 +1,126 @@
+package butil
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	model := newModel(
+		Field(0, "id", Int64),
+		Field(1, "name", String),
+		Field(2, "tags", List(String)),
+	)
+
+	encoded, err := Parse(model, `id: 12 name: "x" tags: ["a", "b"]`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	text, err := Format(model, encoded)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+
+	expected := "id: 12\nname: \"x\"\ntags: [\"a\", \"b\"]\n"
+	if text != expected {
+		t.Errorf("Expected %q, got %q", expected, text)
+	}
+}
+
+func TestFormatNested(t *testing.T) {
+	text := `id: 1
+simple: {
+  id: 2
+  name: "inner"
+}
+children: [
+  {
+    age: 3
+  },
+  {
+    rate: 0.5
+  }
+]
+`
+	encoded, err := Parse(nestedModel, text)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	formatted, err := Format(nestedModel, encoded)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	if formatted != text {
+		t.Errorf("Expected %q, got %q", text, formatted)
+	}
+
+	var decoded NestedStruct
+	if err := nestedModel.Decode(encoded, &decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Simple.Name != "inner" || len(decoded.Children) != 2 || decoded.Children[1].Rate != 0.5 {
+		t.Errorf("Unexpected decoded value %+v", decoded)
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	original := map[string]any{
+		"id":       int64(-42),
+		"name":     "quote \" and \n newline",
+		"tags":     []string{"", "b"},
+		"scores":   []float64{0.1, -2.5e10},
+		"metadata": map[string]int64{"count": 100},
+		"active":   true,
+		"data":     []byte{0xDE, 0xAD, 0x00},
+	}
+
+	encoded, err := complexModel.Encode(original)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	text, err := Format(complexModel, encoded)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+
+	parsed, err := Parse(complexModel, text)
+	if err != nil {
+		t.Fatalf("Parse failed: %v\n%s", err, text)
+	}
+	if !bytes.Equal(parsed, encoded) {
+		t.Errorf("Parsed bytes differ from encoded bytes\n%s", text)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{name: "unknown_label", text: `unknown: 1`},
+		{name: "missing_colon", text: `id 1`},
+		{name: "wrong_type", text: `name: 1`},
+		{name: "out_of_range", text: `age: 2147483648`},
+		{name: "unterminated_string", text: `name: "abc`},
+		{name: "unclosed_list", text: `tags: ["a"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := newModel(
+				Field(0, "id", Int64),
+				Field(1, "name", String),
+				Field(2, "age", Int32),
+				Field(3, "tags", List(String)),
+			)
+			_, err := Parse(model, tt.text)
+			if !errors.Is(err, ErrInput) {
+				t.Errorf("Expected ErrInput, got %v", err)
+			}
+		})
+	}
+}
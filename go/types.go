@@ -2,9 +2,12 @@ package butil
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/binary"
 	"fmt"
 	"reflect"
+	"slices"
+	"strings"
 )
 
 // ? Indirect values before decoding
@@ -37,9 +40,26 @@ const (
 	String
 )
 
+var simpleTypeNames = [13]string{"boolean", "uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64", "float32", "float64", "bytes", "string"}
+
 func (t SimpleType) String() string {
-	typeNames := [13]string{"boolean", "uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64", "float32", "float64", "bytes", "string"}
-	return fmt.Sprintf("butil %s", typeNames[t])
+	return fmt.Sprintf("butil %s", simpleTypeNames[t])
+}
+
+// size returns the encoded size of fixed width types and 0 for length prefixed types.
+func (t SimpleType) size() int {
+	switch t {
+	case Bool, Uint8, Int8:
+		return 1
+	case Uint16, Int16:
+		return 2
+	case Uint32, Int32, Float32:
+		return 4
+	case Uint64, Int64, Float64:
+		return 8
+	default:
+		return 0
+	}
 }
 
 func (t SimpleType) reflectType() (reflect.Type, error) {
@@ -52,6 +72,14 @@ func (t SimpleType) reflectType() (reflect.Type, error) {
 		return reflect.TypeOf(int32(0)), nil
 	case Int64:
 		return reflect.TypeOf(int64(0)), nil
+	case Uint8:
+		return reflect.TypeOf(uint8(0)), nil
+	case Uint16:
+		return reflect.TypeOf(uint16(0)), nil
+	case Uint32:
+		return reflect.TypeOf(uint32(0)), nil
+	case Uint64:
+		return reflect.TypeOf(uint64(0)), nil
 	case Float32:
 		return reflect.TypeOf(float32(0)), nil
 	case Float64:
@@ -80,9 +108,20 @@ func (t ListType) String() string {
 }
 
 func (t ListType) Encode(buf *bytes.Buffer, val reflect.Value) error {
+	if handled, err := encodeMarshaler(buf, t, val); handled {
+		return err
+	}
+	return t.encodeValue(buf, val)
+}
+
+// encodeValue encodes a value as a list without calling its marshaling methods.
+func (t ListType) encodeValue(buf *bytes.Buffer, val reflect.Value) error {
 	if val.Kind() == reflect.Interface {
 		val = val.Elem()
 	}
+	if val.IsValid() && val.Type() == rawMessageType {
+		return encodeRaw(buf, t, RawMessage(val.Bytes()))
+	}
 	if val.Kind() != reflect.Slice {
 		return fmt.Errorf("can not encode value of type %v as %s", val.Kind(), t)
 	}
@@ -102,10 +141,21 @@ func (t ListType) Encode(buf *bytes.Buffer, val reflect.Value) error {
 }
 
 func (t ListType) Decode(buf *bytes.Buffer, v reflect.Value) error {
+	if v.Type() == rawMessageType {
+		return decodeRaw(buf, t, v)
+	}
+	if handled, err := decodeUnmarshaler(buf, t, v); handled {
+		return err
+	}
+
 	var length uint32
 	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
 		return fmt.Errorf("%w: failed to decode list length: %w", ErrBuffer, err)
 	}
+	// Every element takes at least one byte, so longer lists cannot be valid.
+	if length > uint32(buf.Len()) {
+		return fmt.Errorf("%w: list length %d exceeds buffer size %d", ErrBuffer, length, buf.Len())
+	}
 
 	// TODO Make seperate interface functions for each decode to reduce reflect calls
 	var slice reflect.Value
@@ -142,6 +192,14 @@ func (t MapType) String() string {
 }
 
 func (t MapType) Encode(buf *bytes.Buffer, val reflect.Value) error {
+	if handled, err := encodeMarshaler(buf, t, val); handled {
+		return err
+	}
+	return t.encodeValue(buf, val)
+}
+
+// encodeValue encodes a value as a map without calling its marshaling methods.
+func (t MapType) encodeValue(buf *bytes.Buffer, val reflect.Value) error {
 	if val.Kind() == reflect.Interface {
 		val = val.Elem()
 	}
@@ -152,7 +210,10 @@ func (t MapType) Encode(buf *bytes.Buffer, val reflect.Value) error {
 		return err
 	}
 
-	for _, key := range val.MapKeys() {
+	keys := val.MapKeys()
+	slices.SortFunc(keys, compareKeys)
+
+	for _, key := range keys {
 		if !key.CanInterface() || !val.MapIndex(key).CanInterface() {
 			continue
 		}
@@ -167,10 +228,16 @@ func (t MapType) Encode(buf *bytes.Buffer, val reflect.Value) error {
 }
 
 func (t MapType) Decode(buf *bytes.Buffer, v reflect.Value) error {
+	if handled, err := decodeUnmarshaler(buf, t, v); handled {
+		return err
+	}
 	var length uint32
 	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
 		return fmt.Errorf("%w: failed to decode map length: %w", ErrBuffer, err)
 	}
+	if length > uint32(buf.Len()) {
+		return fmt.Errorf("%w: map length %d exceeds buffer size %d", ErrBuffer, length, buf.Len())
+	}
 
 	var newMap reflect.Value
 	if v.Kind() == reflect.Interface {
@@ -219,13 +286,80 @@ func (t ReferenceType) String() string {
 }
 
 func (t ReferenceType) Encode(buf *bytes.Buffer, val reflect.Value) error {
+	if handled, err := encodeMarshaler(buf, t, val); handled {
+		return err
+	}
+	return t.encodeValue(buf, val)
+}
+
+// encodeValue encodes a value as a reference without calling its marshaling methods.
+func (t ReferenceType) encodeValue(buf *bytes.Buffer, val reflect.Value) error {
+	if val.Kind() == reflect.Interface {
+		val = val.Elem()
+	}
+	if !val.IsValid() {
+		return fmt.Errorf("%w: cannot encode nil as %s", ErrInput, t)
+	}
+	if val.Type() == rawMessageType {
+		return encodeRaw(buf, t, RawMessage(val.Bytes()))
+	}
 	return t.model.encode(buf, val.Type(), val)
 }
 
 func (t ReferenceType) Decode(buf *bytes.Buffer, val reflect.Value) error {
+	if val.Type() == rawMessageType {
+		return decodeRaw(buf, t, val)
+	}
+	if handled, err := decodeUnmarshaler(buf, t, val); handled {
+		return err
+	}
+	if val.Kind() == reflect.Interface {
+		fields := make(map[string]any)
+		err := t.model.decode(buf, reflect.TypeOf(fields), reflect.ValueOf(fields))
+		if _, ok := err.(fieldErrors); err != nil && !ok {
+			return err
+		}
+		// Fields that could not be decrypted are reported, but the others are kept.
+		val.Set(reflect.ValueOf(fields))
+		return err
+	}
 	return t.model.decode(buf, val.Type(), val)
 }
 
+// compareKeys orders map keys, so that maps are encoded in a deterministic order.
+func compareKeys(a, b reflect.Value) int {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	if a.Kind() != b.Kind() {
+		return cmp.Compare(a.Kind(), b.Kind())
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Bool:
+		if a.Bool() == b.Bool() {
+			return 0
+		}
+		if b.Bool() {
+			return -1
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
 func indirectValue(v reflect.Value) reflect.Value {
 	for v.Kind() == reflect.Pointer {
 		if v.IsNil() {